@@ -0,0 +1,155 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ErrBudgetExceeded is returned when a run exceeds its instruction budget
+var ErrBudgetExceeded = errors.New("lua: instruction budget exceeded")
+
+// BudgetError is returned when a run is aborted because it has executed more
+// instructions than its budget allows. It matches ErrBudgetExceeded.
+type BudgetError struct {
+	Limit uint64 // The maximum number of instructions allowed
+	Used  uint64 // The number of instructions executed
+}
+
+// Error returns the error message
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s (%d of %d instructions)", ErrBudgetExceeded.Error(), e.Used, e.Limit)
+}
+
+// Is returns whether the error matches the target error
+func (e *BudgetError) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+// --------------------------------------------------------------------
+
+// closed is a closed channel, used to signal that the budget was exceeded
+var closed = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// budget is a context which counts the number of instructions executed and
+// periodically samples the memory used by the state. The VM checks whether the
+// context is done before executing every instruction, so once either limit is
+// exceeded the context is done and the run is aborted.
+type budget struct {
+	context.Context
+	state  *lua.LState // The state being measured
+	limit  uint64      // The maximum number of instructions, or 0
+	used   uint64      // The number of instructions executed
+	memory uint64      // The maximum number of bytes, or 0
+	size   uint64      // The last estimated number of bytes used
+	sample uint64      // The instruction count of the next memory sample
+	sizer  sizer       // The memory estimator
+	err    error       // The reason the budget was exceeded
+}
+
+// reset resets the budget for a new run with a parent context
+func (b *budget) reset(ctx context.Context) {
+	b.Context = ctx
+	b.used = 0
+	b.size = 0
+	b.sample = 0
+	b.err = nil
+}
+
+// Done is called by the VM before executing every instruction.
+func (b *budget) Done() <-chan struct{} {
+	switch {
+	case b.err != nil:
+		return closed
+	case b.limit > 0 && b.used >= b.limit:
+		b.err = ErrBudgetExceeded
+		return closed
+	}
+
+	// Sample the memory periodically, the more objects there are the less often
+	// we sample since walking them is proportionally more expensive.
+	if b.memory > 0 && b.used >= b.sample {
+		b.size = b.sizer.sizeOf(b.state)
+		b.sample = b.used + uint64(256+b.sizer.objects)
+		if b.size > b.memory {
+			b.err = ErrMemoryLimit
+			return closed
+		}
+	}
+
+	b.used++
+	return b.Context.Done()
+}
+
+// Err returns the reason the context is done.
+func (b *budget) Err() error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.Context.Err()
+}
+
+// error returns the typed error if either of the limits was exceeded
+func (b *budget) error() error {
+	switch b.err {
+	case ErrBudgetExceeded:
+		return &BudgetError{Limit: b.limit, Used: b.used}
+	case ErrMemoryLimit:
+		return &MemoryError{Limit: b.memory, Used: b.size}
+	default:
+		return nil
+	}
+}
+
+// --------------------------------------------------------------------
+
+// inheritContext replaces the functions of the coroutine library resuming threads,
+// so that the threads run with the context of the state resuming them. Otherwise,
+// the threads only inherit the context they were created with and the instructions
+// they execute are not counted against the budget of the run.
+func inheritContext(state *lua.LState) {
+	lib, ok := state.GetGlobal(lua.CoroutineLibName).(*lua.LTable)
+	if !ok {
+		return
+	}
+
+	if resume, ok := lib.RawGetString("resume").(*lua.LFunction); ok && resume.IsG {
+		lib.RawSetString("resume", state.NewFunction(func(state *lua.LState) int {
+			inherit(state, state.CheckThread(1))
+			return resume.GFunction(state)
+		}))
+	}
+
+	// The wrapped function reads the thread from its first upvalue, so we call it
+	// from a closure which has the same upvalue.
+	if wrap, ok := lib.RawGetString("wrap").(*lua.LFunction); ok && wrap.IsG {
+		lib.RawSetString("wrap", state.NewFunction(func(state *lua.LState) int {
+			wrap.GFunction(state)
+			fn := state.CheckFunction(-1)
+			state.Pop(1)
+
+			thread := fn.Upvalues[0].Value()
+			state.Push(state.NewClosure(func(state *lua.LState) int {
+				inherit(state, state.ToThread(lua.UpvalueIndex(1)))
+				return fn.GFunction(state)
+			}, thread))
+			return 1
+		}))
+	}
+}
+
+// inherit sets the context of the thread to the one of the state resuming it
+func inherit(state, thread *lua.LState) {
+	if ctx := state.Context(); ctx != nil && thread != nil && thread.Context() != ctx {
+		thread.SetContext(ctx)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudgetExceeded(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	function main()
+		while true do end
+	end`), WithBudget(1000))
+	assert.NoError(t, err)
+
+	_, err = s.Run(context.Background())
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+
+	var budgetErr *BudgetError
+	assert.True(t, errors.As(err, &budgetErr))
+	assert.Equal(t, uint64(1000), budgetErr.Limit)
+	assert.Equal(t, uint64(1000), budgetErr.Used)
+}
+
+func TestBudgetDeterministic(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	function main(n)
+		local sum = 0
+		for i = 1, n do
+			sum = sum + i
+		end
+		return sum
+	end`), WithBudget(100), WithConcurrency(1))
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		out, err := s.Run(context.Background(), 10)
+		assert.NoError(t, err)
+		assert.Equal(t, Number(55), out)
+
+		_, err = s.Run(context.Background(), 1000)
+		assert.ErrorIs(t, err, ErrBudgetExceeded)
+	}
+}
+
+func TestBudgetCancelled(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	function main()
+		while true do end
+	end`), WithBudget(1e12))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = s.Run(ctx)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrBudgetExceeded)
+}
+
+func TestBudgetCoroutines(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	local loaded = coroutine.create(function()
+		while true do end
+	end)
+
+	local function sum()
+		local sum = 0
+		for i = 1, 5e6 do
+			sum = sum + i
+		end
+		return sum
+	end
+
+	function main(name)
+		if name == "wrap" then
+			return coroutine.wrap(sum)()
+		elseif name == "resume" then
+			local ok, v = coroutine.resume(coroutine.create(sum))
+			return v
+		elseif name == "yield" then
+			local gen = coroutine.wrap(function()
+				for i = 1, 3 do coroutine.yield(i) end
+			end)
+			return gen() + gen() + gen()
+		elseif name == "nested" then
+			return coroutine.wrap(function()
+				return coroutine.wrap(sum)()
+			end)()
+		else
+			coroutine.resume(loaded)
+			return 0
+		end
+	end`), WithBudget(1000))
+	assert.NoError(t, err)
+
+	out, err := s.Run(context.Background(), "yield")
+	assert.NoError(t, err)
+	assert.Equal(t, Number(6), out)
+
+	for _, name := range []string{"wrap", "resume", "nested", "loaded"} {
+		_, err := s.Run(context.Background(), name)
+		assert.ErrorIs(t, err, ErrBudgetExceeded, name)
+	}
+}
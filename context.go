@@ -0,0 +1,85 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"math"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// contextOf returns the context of the run in progress on the state
+func contextOf(state *lua.LState) context.Context {
+	switch ctx := state.Context().(type) {
+	case nil:
+		return context.Background()
+	case *budget:
+		return ctx.Context
+	default:
+		return ctx
+	}
+}
+
+// contextLoader returns the loader of the context module, which exposes the
+// context of the run in progress to the script. Only the values of the keys
+// explicitly allowed by the host can be read by the script.
+func contextLoader(values map[string]any) lua.LGFunction {
+	return func(state *lua.LState) int {
+		t := state.NewTable()
+		state.SetFuncs(t, map[string]lua.LGFunction{
+			"remaining": contextRemaining,
+			"deadline":  contextDeadline,
+			"done":      contextDone,
+			"value": func(state *lua.LState) int {
+				key, ok := values[state.CheckString(1)]
+				if !ok {
+					state.Push(lua.LNil)
+					return 1
+				}
+
+				state.Push(lvalueOf(state, contextOf(state).Value(key)))
+				return 1
+			},
+		})
+
+		state.Push(t)
+		return 1
+	}
+}
+
+// contextRemaining returns the number of seconds remaining until the deadline,
+// or math.huge if the context has no deadline. Note that math.huge is the maximum
+// float rather than infinity in this implementation of LUA.
+func contextRemaining(state *lua.LState) int {
+	deadline, ok := contextOf(state).Deadline()
+	switch {
+	case !ok:
+		state.Push(lua.LNumber(math.MaxFloat64))
+	default:
+		state.Push(lua.LNumber(time.Until(deadline).Seconds()))
+	}
+	return 1
+}
+
+// contextDeadline returns the deadline as a unix timestamp in seconds, or nil if
+// the context has no deadline.
+func contextDeadline(state *lua.LState) int {
+	deadline, ok := contextOf(state).Deadline()
+	switch {
+	case !ok:
+		state.Push(lua.LNil)
+	default:
+		state.Push(lua.LNumber(float64(deadline.UnixNano()) / 1e9))
+	}
+	return 1
+}
+
+// contextDone returns whether the context is done, either because it was
+// cancelled or its deadline was exceeded.
+func contextDone(state *lua.LState) int {
+	state.Push(lua.LBool(contextOf(state).Err() != nil))
+	return 1
+}
@@ -0,0 +1,106 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type contextKey string
+
+func TestContextModule(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	local context = require("context")
+	function main()
+		return {
+			remaining = context.remaining(),
+			deadline  = context.deadline(),
+			done      = context.done(),
+			tenant    = context.value("tenant"),
+			secret    = context.value("secret"),
+		}
+	end`), WithContextValues(map[string]any{
+		"tenant": contextKey("tenant"),
+	}), WithBudget(1000))
+	assert.NoError(t, err)
+
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	ctx = context.WithValue(ctx, contextKey("tenant"), "acme")
+	ctx = context.WithValue(ctx, contextKey("secret"), "password")
+
+	out, err := s.Run(ctx)
+	assert.NoError(t, err)
+
+	result := out.(Table)
+	assert.InDelta(t, 60, float64(result["remaining"].(Number)), 1)
+	assert.InDelta(t, float64(deadline.Unix()), float64(result["deadline"].(Number)), 1)
+	assert.Equal(t, Bool(false), result["done"])
+	assert.Equal(t, String("acme"), result["tenant"])
+	assert.Nil(t, result["secret"])
+}
+
+func TestContextDone(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	local context = require("context")
+	function main()
+		local n = 0
+		while not context.done() do
+			n = n + 1
+		end
+		return context.deadline() == nil and context.remaining() == math.huge
+	end`))
+	assert.NoError(t, err)
+
+	// A cancelled context aborts the run
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.Run(ctx)
+	assert.Error(t, err)
+
+	// Without a deadline, the remaining time is unlimited
+	s, err = New("test.lua", strings.NewReader(`
+	local context = require("context")
+	function main()
+		return context.deadline() == nil and context.remaining() == math.huge and not context.done()
+	end`))
+	assert.NoError(t, err)
+
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Bool(true), out)
+}
+
+func TestContextScriptModule(t *testing.T) {
+	module, err := New("tenant", strings.NewReader(`
+	local context = require("context")
+	return {
+		tenant = function() return context.value("tenant") end
+	}`), WithContextValues(map[string]any{
+		"tenant": contextKey("other"),
+	}))
+	assert.NoError(t, err)
+
+	s, err := New("test.lua", strings.NewReader(`
+	local tenant = require("tenant")
+	function main()
+		return tenant.tenant()
+	end`), WithModules(&ScriptModule{Script: module, Name: "tenant"}), WithContextValues(map[string]any{
+		"tenant": contextKey("tenant"),
+	}))
+	assert.NoError(t, err)
+
+	// The context values allowed by the script which is run take precedence
+	ctx := context.WithValue(context.Background(), contextKey("tenant"), "acme")
+	ctx = context.WithValue(ctx, contextKey("other"), "other")
+	out, err := s.Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, String("acme"), out)
+}
@@ -5,6 +5,9 @@ package lua
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"reflect"
 
 	lua "github.com/yuin/gopher-lua"
@@ -124,17 +127,21 @@ func resultOf(v lua.LValue) Value {
 		return Bool(v)
 	case *lua.LTable:
 
-		// slice cases
+		// slice cases, the tables with a hash part are converted as maps
 		if top := v.RawGetInt(1); top != nil {
 			switch top.Type() {
-			case lua.LTNumber:
-				return asNumbers(v)
-			case lua.LTString:
-				return asStrings(v)
-			case lua.LTBool:
-				return asBools(v)
-			case lua.LTTable:
-				return asArrays(v)
+			case lua.LTNumber, lua.LTString, lua.LTBool, lua.LTTable:
+				switch typ, ok := sequenceOf(v); {
+				case !ok:
+				case typ == lua.LTNumber:
+					return asNumbers(v)
+				case typ == lua.LTString:
+					return asStrings(v)
+				case typ == lua.LTBool:
+					return asBools(v)
+				default:
+					return asArrays(v)
+				}
 			}
 		}
 		// map case
@@ -150,6 +157,23 @@ func resultOf(v lua.LValue) Value {
 	}
 }
 
+// sequenceOf returns whether the table is a sequence, with keys from 1 to its length,
+// and the type of its elements or nil if they are mixed.
+func sequenceOf(t *lua.LTable) (typ lua.LValueType, ok bool) {
+	typ, ok = t.RawGetInt(1).Type(), true
+	size, count := t.Len(), 0
+	t.ForEach(func(k, v lua.LValue) {
+		count++
+		if n, isNum := k.(lua.LNumber); !isNum || n != lua.LNumber(int(n)) || int(n) < 1 || int(n) > size {
+			ok = false
+		}
+		if v.Type() != typ {
+			typ = lua.LTNil
+		}
+	})
+	return typ, ok && count == size
+}
+
 func asNumbers(t *lua.LTable) (out Numbers) {
 	t.ForEach(func(_, v lua.LValue) {
 		out = append(out, float64(v.(lua.LNumber)))
@@ -182,11 +206,10 @@ func asTable(t *lua.LTable) Table {
 }
 
 func asArrays(t *lua.LTable) Array {
-	out, index := make(Array, t.Len()), 0
-	t.ForEach(func(_ lua.LValue, v lua.LValue) {
-		out[index] = resultOf(v)
-		index += 1
-	})
+	out := make(Array, t.Len())
+	for i := range out {
+		out[i] = resultOf(t.RawGetInt(i + 1))
+	}
 	return out
 }
 
@@ -226,3 +249,178 @@ func lvalueOf(exec *lua.LState, value any) lua.LValue {
 		}
 	}
 }
+
+// --------------------------------------------------------------------
+
+// errNotInteger is returned when a number with a fraction is passed as an integer
+var errNotInteger = errors.New("number has no integer representation")
+
+// argumentOf converts a LUA argument into a parameter of a native function, which
+// can either be one of our values or a plain Go type.
+func argumentOf(rt reflect.Type, value lua.LValue) (reflect.Value, error) {
+	v := resultOf(value)
+	switch {
+	case rt == typeValue:
+		return reflect.ValueOf(v), nil
+	case reflect.TypeOf(v) == rt:
+		return reflect.ValueOf(v), nil
+	case value == lua.LNil && rt.Kind() == reflect.Struct:
+		return reflect.New(rt).Elem(), fmt.Errorf("%s expected, got nil", rt)
+	default:
+		return convertTo(rt, v.Native())
+	}
+}
+
+// convertTo converts a native value into the type, checking the range of numbers
+// and mapping the tables onto the fields of structs.
+func convertTo(rt reflect.Type, value any) (reflect.Value, error) {
+	out := reflect.New(rt).Elem()
+	switch rt.Kind() {
+	case reflect.Interface:
+		switch {
+		case rt == typeValue:
+			out.Set(reflect.ValueOf(ValueOf(value)))
+		case value != nil:
+			out.Set(reflect.ValueOf(value))
+		}
+		return out, nil
+
+	case reflect.String:
+		if v, ok := value.(string); ok {
+			out.SetString(v)
+			return out, nil
+		}
+
+	case reflect.Bool:
+		if v, ok := value.(bool); ok {
+			out.SetBool(v)
+			return out, nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v, ok := value.(float64); ok {
+			switch {
+			case v != math.Trunc(v):
+				return out, errNotInteger
+			case v < -(1<<63) || v >= 1<<63 || out.OverflowInt(int64(v)):
+				return out, fmt.Errorf("number is out of range of %s", rt)
+			}
+
+			out.SetInt(int64(v))
+			return out, nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v, ok := value.(float64); ok {
+			switch {
+			case v != math.Trunc(v):
+				return out, errNotInteger
+			case v < 0 || v >= 1<<64 || out.OverflowUint(uint64(v)):
+				return out, fmt.Errorf("number is out of range of %s", rt)
+			}
+
+			out.SetUint(uint64(v))
+			return out, nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if v, ok := value.(float64); ok {
+			if out.OverflowFloat(v) {
+				return out, fmt.Errorf("number is out of range of %s", rt)
+			}
+
+			out.SetFloat(v)
+			return out, nil
+		}
+
+	case reflect.Slice:
+		switch src := reflect.ValueOf(value); {
+		case value == nil:
+			return out, nil
+		case src.Kind() == reflect.Slice:
+			out = reflect.MakeSlice(rt, src.Len(), src.Len())
+			for i := 0; i < src.Len(); i++ {
+				elem, err := convertTo(rt.Elem(), src.Index(i).Interface())
+				if err != nil {
+					return out, fmt.Errorf("element #%d: %w", i+1, err)
+				}
+				out.Index(i).Set(elem)
+			}
+			return out, nil
+		}
+
+	case reflect.Map:
+		switch src := value.(type) {
+		case nil:
+			return out, nil
+		case map[string]any:
+			out = reflect.MakeMapWithSize(rt, len(src))
+			for k, v := range src {
+				elem, err := convertTo(rt.Elem(), v)
+				if err != nil {
+					return out, fmt.Errorf("field '%s': %w", k, err)
+				}
+				out.SetMapIndex(reflect.ValueOf(k).Convert(rt.Key()), elem)
+			}
+			return out, nil
+		}
+
+	case reflect.Struct, reflect.Pointer:
+		switch value.(type) {
+		case nil: // Empty tables are converted to nil
+			return out, nil
+		case map[string]any:
+			ptr := reflect.New(rt)
+			if b, err := json.Marshal(value); err == nil {
+				if err := json.Unmarshal(b, ptr.Interface()); err != nil {
+					return out, fmt.Errorf("%s expected: %w", rt, err)
+				}
+				return ptr.Elem(), nil
+			}
+		}
+	}
+
+	return out, fmt.Errorf("%s expected, got %s", rt, typeNameOf(value))
+}
+
+// typeNameOf returns the LUA type name of a native value
+func typeNameOf(value any) string {
+	switch value.(type) {
+	case nil:
+		return "nil"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	default:
+		return "table"
+	}
+}
+
+// isConvertible returns whether a type can be converted to and from LUA values
+func isConvertible(rt reflect.Type) bool {
+	if _, ok := typeMap[rt]; ok {
+		return true
+	}
+
+	switch rt.Kind() {
+	case reflect.Interface:
+		return rt.NumMethod() == 0
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	case reflect.Slice:
+		return isConvertible(rt.Elem())
+	case reflect.Map:
+		return rt.Key().Kind() == reflect.String && isConvertible(rt.Elem())
+	case reflect.Struct:
+		return true
+	case reflect.Pointer:
+		return rt.Elem().Kind() == reflect.Struct
+	default:
+		return false
+	}
+}
@@ -4,6 +4,7 @@
 package lua
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -142,3 +143,67 @@ func TestResultOfMap(t *testing.T) {
 		assert.Equal(t, mp[key], resMp[key])
 	}
 }
+
+func TestResultOfMixed(t *testing.T) {
+	l := lua.NewState()
+	defer l.Close()
+
+	tbl := l.NewTable()
+	tbl.Append(lua.LString("a"))
+	tbl.Append(lua.LNumber(1))
+	assert.Equal(t, Array{String("a"), Number(1)}, resultOf(tbl))
+
+	// Tables with a hash part are converted as maps
+	tbl.RawSetString("x", lua.LString("b"))
+	assert.Equal(t, Table{"x": String("b")}, resultOf(tbl))
+
+	hash := l.NewTable()
+	hash.Append(lua.LNumber(1))
+	hash.RawSetString("x", lua.LString("a"))
+	assert.Equal(t, Table{"x": String("a")}, resultOf(hash))
+}
+
+func TestConvertTo(t *testing.T) {
+	type point struct {
+		X int `json:"x"`
+	}
+
+	tests := []struct {
+		input  any
+		expect any
+		err    string
+	}{
+		{input: "a", expect: "a"},
+		{input: true, expect: true},
+		{input: 42.0, expect: int64(42)},
+		{input: 255.0, expect: uint8(255)},
+		{input: 1.5, expect: float32(1.5)},
+		{input: nil, expect: []string(nil)},
+		{input: []any{"a", "b"}, expect: []string{"a", "b"}},
+		{input: []float64{1, 2}, expect: []int{1, 2}},
+		{input: map[string]any{"a": 1.0}, expect: map[string]int{"a": 1}},
+		{input: map[string]any{"x": 1.0}, expect: point{X: 1}},
+		{input: map[string]any{"x": 1.0}, expect: &point{X: 1}},
+		{input: 1.0, expect: Number(1)},
+		{input: []any{"a", 1.0}, expect: Array{String("a"), Number(1)}},
+		{input: 1.5, expect: int64(0), err: "number has no integer representation"},
+		{input: 256.0, expect: uint8(0), err: "number is out of range of uint8"},
+		{input: -1.0, expect: uint(0), err: "number is out of range of uint"},
+		{input: 1e20, expect: int64(0), err: "number is out of range of int64"},
+		{input: 1.0, expect: "", err: "string expected, got number"},
+		{input: nil, expect: false, err: "bool expected, got nil"},
+		{input: "x", expect: point{}, err: "lua.point expected, got string"},
+		{input: []any{"a", 1.0}, expect: []string(nil), err: "element #2: string expected, got number"},
+	}
+
+	for _, tc := range tests {
+		out, err := convertTo(reflect.TypeOf(tc.expect), tc.input)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err)
+			continue
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expect, out.Interface())
+	}
+}
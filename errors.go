@@ -0,0 +1,266 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// ErrorKind represents the kind of a script error
+type ErrorKind uint8
+
+// Various kinds of script errors
+const (
+	KindRuntime   ErrorKind = iota // The script raised an error while running
+	KindSyntax                     // The script failed to compile
+	KindTimeout                    // The deadline of the run was exceeded
+	KindCancelled                  // The run was cancelled
+	KindLimit                      // The instruction budget or memory limit was exceeded
+)
+
+// String returns the name of the kind
+func (k ErrorKind) String() string {
+	switch k {
+	case KindRuntime:
+		return "runtime"
+	case KindSyntax:
+		return "syntax"
+	case KindTimeout:
+		return "timeout"
+	case KindCancelled:
+		return "cancelled"
+	case KindLimit:
+		return "limit"
+	default:
+		return "unknown"
+	}
+}
+
+// ScriptError represents an error which occurred while compiling or running a
+// script. The underlying error, such as context.DeadlineExceeded or a *BudgetError,
+// can be inspected with errors.Is and errors.As.
+type ScriptError struct {
+	Kind      ErrorKind // The kind of the error
+	Script    string    // The name of the script
+	Function  string    // The name of the function which failed, if known
+	Line      int       // The line at which the error occurred, or 0 if unknown
+	Traceback string    // The LUA stack traceback, if any
+	Value     Value     // The error value raised by the script
+	Err       error     // The underlying error
+}
+
+// Error returns the error message
+func (e *ScriptError) Error() string {
+	msg := "unknown error"
+	switch {
+	case e.Kind != KindRuntime && e.Kind != KindSyntax && e.Err != nil:
+		msg = e.Err.Error()
+	case e.Value != nil:
+		msg = e.Value.String()
+	case e.Err != nil:
+		msg = e.Err.Error()
+	}
+
+	// LUA already prefixes the string errors with their position
+	_, positioned := e.Value.(String)
+	switch {
+	case e.Kind == KindRuntime && positioned:
+		return msg
+	case e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.Script, e.Line, msg)
+	default:
+		return fmt.Sprintf("%s: %s", e.Script, msg)
+	}
+}
+
+// Unwrap returns the underlying error
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// syntaxError converts a compilation error into a *ScriptError
+func syntaxError(script string, err error) error {
+	e := &ScriptError{
+		Kind:   KindSyntax,
+		Script: script,
+		Value:  String(err.Error()),
+		Err:    err,
+	}
+
+	var parseErr *parse.Error
+	var compileErr *lua.CompileError
+	switch {
+	case errors.As(err, &parseErr):
+		e.Value = String(parseErr.Message)
+		if parseErr.Token != "" {
+			e.Value = String(fmt.Sprintf("%s near '%s'", parseErr.Message, parseErr.Token))
+		}
+		if parseErr.Pos.Line > 0 {
+			e.Line = parseErr.Pos.Line
+		}
+	case errors.As(err, &compileErr):
+		e.Value = String(compileErr.Message)
+		e.Line = compileErr.Line
+	}
+	return e
+}
+
+// --------------------------------------------------------------------
+
+// trace represents the position of an error, captured before the stack is unwound
+type trace struct {
+	function  string // The name of the function which failed
+	line      int    // The line at which the error occurred
+	traceback string // The stack traceback
+}
+
+// onError is the error handler of the VM, which captures the position of the error
+// and the stack traceback. The error value is returned unchanged.
+func (v *vm) onError(state *lua.LState) int {
+	v.trace = capture(state, v.entry)
+	return 1
+}
+
+// capture captures the position of the error being raised on the state. The
+// function called by the host, if any, is used for the name of the outermost frame.
+func capture(state *lua.LState, entry string) (t trace) {
+	var frames []string
+	for i := 1; ; i++ {
+		dbg, ok := state.GetStack(i)
+		if !ok {
+			break
+		}
+
+		name := "main chunk"
+		state.GetInfo("nSl", dbg, lua.LNil)
+		switch {
+		case dbg.What == "main" && entry != "":
+			name = fmt.Sprintf("function '%s'", entry)
+			dbg.Name = entry
+		case dbg.What == "main":
+			dbg.Name = ""
+		case strings.HasPrefix(dbg.Name, "<") || strings.HasPrefix(dbg.Name, "("):
+			name = fmt.Sprintf("function %s", dbg.Name)
+		default:
+			name = fmt.Sprintf("function '%s'", dbg.Name)
+		}
+
+		// The error occurred in the innermost function written in LUA
+		if t.line == 0 && dbg.CurrentLine > 0 {
+			t.function = dbg.Name
+			t.line = dbg.CurrentLine
+		}
+
+		frames = append(frames, fmt.Sprintf("\t%s in %s", state.Where(i), name))
+	}
+
+	// Keep the traceback short, same as the one of the LUA runtime
+	if len(frames) > 20 {
+		frames = append(append(frames[:7:7], "\t..."), frames[len(frames)-7:]...)
+	}
+
+	t.traceback = "stack traceback:\n" + strings.Join(frames, "\n")
+	return
+}
+
+// failure converts the error of a failed call into a *ScriptError
+func (v *vm) failure(ctx context.Context, err error) error {
+	e := &ScriptError{
+		Kind:      KindRuntime,
+		Script:    v.script,
+		Function:  v.trace.function,
+		Line:      v.trace.line,
+		Traceback: v.trace.traceback,
+		Err:       err,
+	}
+
+	if apiErr, ok := err.(*lua.ApiError); ok {
+		e.Value = resultOf(apiErr.Object)
+		if msg, ok := apiErr.Object.(lua.LString); ok {
+			if native, ok := v.nativeErrorOf(string(msg)); ok {
+				e.Err = native
+			}
+		}
+	}
+
+	switch exceeded := v.spent.error(); {
+	case exceeded != nil:
+		e.Kind, e.Err = KindLimit, exceeded
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		e.Kind, e.Err = KindTimeout, ctx.Err()
+	case errors.Is(ctx.Err(), context.Canceled):
+		e.Kind, e.Err = KindCancelled, ctx.Err()
+	}
+	return e
+}
+
+// --------------------------------------------------------------------
+
+// PanicError is the underlying error of a run during which a native function has
+// panicked. The VM which ran it is replaced with a fresh one.
+type PanicError struct {
+	Function string // The name of the native function
+	Value    any    // The value the function panicked with
+	Stack    string // The Go stack trace of the panic
+}
+
+// Error returns the error message
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("lua: %s() panicked: %v", e.Function, e.Value)
+}
+
+// raise raises an error returned by a native function. The scripts receive the
+// message as a plain string, so they can handle it as any other error, while the
+// VM remembers the error raised with every message until the next call, so that
+// the host can still unwrap it.
+func raise(state *lua.LState, err error) {
+	msg := err.Error()
+	if at := where(state); at != "" {
+		msg = at + " " + msg
+	}
+
+	if v := vmOf(state); v != nil {
+		if v.natives == nil {
+			v.natives = make(map[string]error, 4)
+		}
+		v.natives[msg] = err
+	}
+	state.Error(lua.LString(msg), 0)
+}
+
+// nativeErrorOf returns the native error which was raised with the message, either
+// as is or raised again by the script with the positions of the callers prepended.
+func (v *vm) nativeErrorOf(msg string) (error, bool) {
+	for {
+		if err, ok := v.natives[msg]; ok {
+			return err, true
+		}
+
+		i := strings.Index(msg, ": ")
+		if i < 0 {
+			return nil, false
+		}
+		msg = msg[i+2:]
+	}
+}
+
+// where returns the position of the innermost function written in LUA
+func where(state *lua.LState) string {
+	for i := 1; ; i++ {
+		dbg, ok := state.GetStack(i)
+		if !ok {
+			return ""
+		}
+
+		if _, err := state.GetInfo("Sl", dbg, lua.LNil); err == nil && dbg.CurrentLine > 0 {
+			return fmt.Sprintf("%s:%d:", dbg.Source, dbg.CurrentLine)
+		}
+	}
+}
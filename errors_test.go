@@ -0,0 +1,255 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScriptErrorRuntime(t *testing.T) {
+	s, err := FromString("test.lua", `
+	local function validate(n)
+		if n < 0 then
+			error("negative input")
+		end
+	end
+
+	function main(n)
+		validate(n)
+		return n
+	end`)
+	assert.NoError(t, err)
+
+	_, err = s.Run(context.Background(), -1)
+	var scriptErr *ScriptError
+	assert.ErrorAs(t, err, &scriptErr)
+	assert.Equal(t, KindRuntime, scriptErr.Kind)
+	assert.Equal(t, "test.lua", scriptErr.Script)
+	assert.Equal(t, "validate", scriptErr.Function)
+	assert.Equal(t, 4, scriptErr.Line)
+	assert.Equal(t, String("test.lua:4: negative input"), scriptErr.Value)
+	assert.Equal(t, "test.lua:4: negative input", err.Error())
+	assert.Contains(t, scriptErr.Traceback, "test.lua:4: in function 'validate'")
+	assert.Contains(t, scriptErr.Traceback, "test.lua:9: in function 'main'")
+}
+
+func TestScriptErrorValue(t *testing.T) {
+	s, err := FromString("test.lua", `
+	function main()
+		error({code = 404})
+	end`)
+	assert.NoError(t, err)
+
+	_, err = s.Run(context.Background())
+	var scriptErr *ScriptError
+	assert.ErrorAs(t, err, &scriptErr)
+	assert.Equal(t, "main", scriptErr.Function)
+	assert.Equal(t, 3, scriptErr.Line)
+	assert.Equal(t, Table{"code": Number(404)}, scriptErr.Value)
+	assert.True(t, strings.HasPrefix(err.Error(), "test.lua:3: "))
+}
+
+func TestScriptErrorSyntax(t *testing.T) {
+	_, err := FromString("test.lua", `
+	function main()
+		return 1 +
+	end`)
+
+	var scriptErr *ScriptError
+	assert.ErrorAs(t, err, &scriptErr)
+	assert.Equal(t, KindSyntax, scriptErr.Kind)
+	assert.Equal(t, 4, scriptErr.Line)
+	assert.Contains(t, err.Error(), "test.lua:4: ")
+}
+
+func TestScriptErrorInit(t *testing.T) {
+	_, err := FromString("test.lua", `
+	function init()
+		error("broken")
+	end`)
+
+	var scriptErr *ScriptError
+	assert.ErrorAs(t, err, &scriptErr)
+	assert.Equal(t, KindRuntime, scriptErr.Kind)
+	assert.Equal(t, "init", scriptErr.Function)
+	assert.Equal(t, 3, scriptErr.Line)
+}
+
+func TestScriptErrorContext(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	function main()
+		while true do end
+	end`))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = s.Run(ctx)
+	var scriptErr *ScriptError
+	assert.ErrorAs(t, err, &scriptErr)
+	assert.Equal(t, KindTimeout, scriptErr.Kind)
+	assert.Equal(t, 3, scriptErr.Line)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	_, err = s.Run(ctx)
+	assert.ErrorAs(t, err, &scriptErr)
+	assert.Equal(t, KindCancelled, scriptErr.Kind)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestScriptErrorLimit(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	function main()
+		while true do end
+	end`), WithBudget(100))
+	assert.NoError(t, err)
+
+	_, err = s.Run(context.Background())
+	var scriptErr *ScriptError
+	assert.ErrorAs(t, err, &scriptErr)
+	assert.Equal(t, KindLimit, scriptErr.Kind)
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+	assert.True(t, errors.Is(err, ErrBudgetExceeded))
+}
+
+func TestErrorKind(t *testing.T) {
+	for kind, name := range map[ErrorKind]string{
+		KindRuntime:    "runtime",
+		KindSyntax:     "syntax",
+		KindTimeout:    "timeout",
+		KindCancelled:  "cancelled",
+		KindLimit:      "limit",
+		ErrorKind(255): "unknown",
+	} {
+		assert.Equal(t, name, kind.String())
+	}
+}
+
+var errNotFound = errors.New("not found")
+
+func TestNativeError(t *testing.T) {
+	m := &NativeModule{Name: "store"}
+	assert.NoError(t, m.Register("get", func(key String) (String, error) {
+		return "", fmt.Errorf("unable to get %s: %w", key, errNotFound)
+	}))
+
+	s, err := FromString("test.lua", `
+	local store = require("store")
+	function main()
+		return store.get("a")
+	end
+
+	function caught()
+		local ok, err = pcall(store.get, "b")
+		return tostring(err) .. " / " .. err .. " / " .. type(err)
+	end
+
+	function inspect()
+		local ok, err = pcall(store.get, "d")
+		local found, at = pcall(string.find, err, "not")
+		return found, at, err:find("not"), err:upper()
+	end
+
+	function rethrow()
+		local ok, err = pcall(store.get, "c")
+		error(err)
+	end
+
+	function later()
+		local ok, first = pcall(store.get, "e")
+		for i = 1, 10 do
+			pcall(store.get, "f" .. i)
+		end
+		error(first, 0)
+	end`, m)
+	assert.NoError(t, err)
+
+	// The error returned by the native function is unwrapped
+	_, err = s.Run(context.Background())
+	var scriptErr *ScriptError
+	assert.ErrorIs(t, err, errNotFound)
+	assert.ErrorAs(t, err, &scriptErr)
+	assert.Equal(t, "main", scriptErr.Function)
+	assert.Equal(t, 4, scriptErr.Line)
+	assert.Equal(t, "test.lua:4: unable to get a: not found", err.Error())
+
+	// The scripts can catch the error and handle it as a string
+	out, err := s.Call(context.Background(), "caught")
+	assert.NoError(t, err)
+	assert.Equal(t, String(
+		"test.lua:8: unable to get b: not found / test.lua:8: unable to get b: not found / string",
+	), out)
+
+	// The string library can be used to inspect the error
+	multi, err := s.CallMulti(context.Background(), "inspect")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{Bool(true), Number(31), Number(31), String("TEST.LUA:13: UNABLE TO GET D: NOT FOUND")}, multi)
+
+	// The error is preserved when raised again by the script
+	_, err = s.Call(context.Background(), "rethrow")
+	assert.ErrorIs(t, err, errNotFound)
+
+	// The error is preserved after catching many others
+	_, err = s.Call(context.Background(), "later")
+	assert.ErrorIs(t, err, errNotFound)
+	assert.Equal(t, "test.lua:24: unable to get e: not found", err.Error())
+}
+
+func TestNativePanic(t *testing.T) {
+	m := &NativeModule{Name: "store"}
+	assert.NoError(t, m.Register("get", func(key String) (String, error) {
+		var cache map[string]string
+		cache[string(key)] = "boom"
+		return "", nil
+	}))
+	assert.NoError(t, m.Register("name", func(key String) (String, error) {
+		var names []String
+		return names[len(key)], nil
+	}))
+
+	s, err := New("test.lua", strings.NewReader(`
+	local store = require("store")
+	function main(key)
+		return store.get(key)
+	end
+
+	function caught()
+		local ok, err = pcall(store.name, "a")
+		return ok
+	end`), WithModules(m), WithConcurrency(1))
+	assert.NoError(t, err)
+
+	// The panic is returned as an error
+	_, err = s.Run(context.Background(), "a")
+	var scriptErr *ScriptError
+	var panicErr *PanicError
+	assert.ErrorAs(t, err, &scriptErr)
+	assert.ErrorAs(t, err, &panicErr)
+	assert.Equal(t, 4, scriptErr.Line)
+	assert.Equal(t, "get", panicErr.Function)
+	assert.Contains(t, panicErr.Stack, "errors_test.go")
+	assert.Contains(t, err.Error(), "lua: get() panicked: assignment to entry in nil map")
+	assert.Eventually(t, func() bool {
+		return s.Stats().Recycled == 1 && s.Stats().Idle == 1
+	}, time.Second, time.Millisecond)
+
+	// Even if caught by the script, the VM is replaced
+	out, err := s.Call(context.Background(), "caught")
+	assert.NoError(t, err)
+	assert.Equal(t, Bool(false), out)
+	assert.Eventually(t, func() bool {
+		return s.Stats().Recycled == 2 && s.Stats().Idle == 1
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,6 @@
+local api = require("test")
+
+function main(a, b)
+    local q, r = api.divmod(a, b)
+    return q, r
+end
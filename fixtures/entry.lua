@@ -0,0 +1,11 @@
+function validate(input)
+    return input > 0
+end
+
+function transform(input)
+    return input * 2
+end
+
+function on_error(message)
+    return "error: " .. message
+end
@@ -0,0 +1,316 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Func represents a native function with typed parameters, created with one of the
+// FuncN or ActionN helpers. It can be registered into a module and is called by the
+// script without reflection.
+type Func struct {
+	compile func(name string) lua.LGFunction
+}
+
+// The functions with scalar parameters are called without reflection, even when
+// they are registered directly rather than through the helpers.
+func init() {
+	scalars[String]()
+	scalars[Number]()
+	scalars[Bool]()
+}
+
+// scalars registers the builtin makers of the functions taking a parameter of a type
+func scalars[A Value]() {
+	register(Action1[A])
+	register(Func0[A])
+	register(action1[A])
+	register(func0[A])
+	binary[A, String]()
+	binary[A, Number]()
+	binary[A, Bool]()
+}
+
+// binary registers the builtin makers of the functions taking and returning a value
+func binary[A, R Value]() {
+	register(Func1[A, R])
+	register(func1[A, R])
+}
+
+// register registers a builtin maker for the type of function it takes
+func register[F any](maker func(F) Func) {
+	builtin[typeOf[F]()] = func(fn any) Func {
+		return maker(fn.(F))
+	}
+}
+
+// --------------------------------------------------------------------
+
+// Func0 creates a native function which returns a value
+func Func0[R Value](fn func() (R, error)) Func {
+	return func0(func(context.Context) (R, error) {
+		return fn()
+	})
+}
+
+// Func1 creates a native function which takes one value and returns a value
+func Func1[A, R Value](fn func(A) (R, error)) Func {
+	return func1(func(_ context.Context, a A) (R, error) {
+		return fn(a)
+	})
+}
+
+// Func2 creates a native function which takes two values and returns a value
+func Func2[A, B, R Value](fn func(A, B) (R, error)) Func {
+	sig := signatureFor(optional[A](), optional[B]())
+	return Func{func(name string) lua.LGFunction {
+		return func(state *lua.LState) int {
+			if !sig.verify(state, name) {
+				return 0
+			}
+
+			r, err := fn(argOf[A](state, name, 1), argOf[B](state, name, 2))
+			return push(state, r, err)
+		}
+	}}
+}
+
+// Func3 creates a native function which takes three values and returns a value
+func Func3[A, B, C, R Value](fn func(A, B, C) (R, error)) Func {
+	sig := signatureFor(optional[A](), optional[B](), optional[C]())
+	return Func{func(name string) lua.LGFunction {
+		return func(state *lua.LState) int {
+			if !sig.verify(state, name) {
+				return 0
+			}
+
+			r, err := fn(argOf[A](state, name, 1), argOf[B](state, name, 2), argOf[C](state, name, 3))
+			return push(state, r, err)
+		}
+	}}
+}
+
+// Func4 creates a native function which takes four values and returns a value
+func Func4[A, B, C, D, R Value](fn func(A, B, C, D) (R, error)) Func {
+	sig := signatureFor(optional[A](), optional[B](), optional[C](), optional[D]())
+	return Func{func(name string) lua.LGFunction {
+		return func(state *lua.LState) int {
+			if !sig.verify(state, name) {
+				return 0
+			}
+
+			r, err := fn(argOf[A](state, name, 1), argOf[B](state, name, 2), argOf[C](state, name, 3), argOf[D](state, name, 4))
+			return push(state, r, err)
+		}
+	}}
+}
+
+// Action0 creates a native function which returns nothing
+func Action0(fn func() error) Func {
+	sig := signatureFor()
+	return Func{func(name string) lua.LGFunction {
+		return func(state *lua.LState) int {
+			if !sig.verify(state, name) {
+				return 0
+			}
+
+			return check(state, fn())
+		}
+	}}
+}
+
+// Action1 creates a native function which takes one value and returns nothing
+func Action1[A Value](fn func(A) error) Func {
+	return action1(func(_ context.Context, a A) error {
+		return fn(a)
+	})
+}
+
+// Action2 creates a native function which takes two values and returns nothing
+func Action2[A, B Value](fn func(A, B) error) Func {
+	sig := signatureFor(optional[A](), optional[B]())
+	return Func{func(name string) lua.LGFunction {
+		return func(state *lua.LState) int {
+			if !sig.verify(state, name) {
+				return 0
+			}
+
+			return check(state, fn(argOf[A](state, name, 1), argOf[B](state, name, 2)))
+		}
+	}}
+}
+
+// Action3 creates a native function which takes three values and returns nothing
+func Action3[A, B, C Value](fn func(A, B, C) error) Func {
+	sig := signatureFor(optional[A](), optional[B](), optional[C]())
+	return Func{func(name string) lua.LGFunction {
+		return func(state *lua.LState) int {
+			if !sig.verify(state, name) {
+				return 0
+			}
+
+			return check(state, fn(argOf[A](state, name, 1), argOf[B](state, name, 2), argOf[C](state, name, 3)))
+		}
+	}}
+}
+
+// Action4 creates a native function which takes four values and returns nothing
+func Action4[A, B, C, D Value](fn func(A, B, C, D) error) Func {
+	sig := signatureFor(optional[A](), optional[B](), optional[C](), optional[D]())
+	return Func{func(name string) lua.LGFunction {
+		return func(state *lua.LState) int {
+			if !sig.verify(state, name) {
+				return 0
+			}
+
+			return check(state, fn(argOf[A](state, name, 1), argOf[B](state, name, 2), argOf[C](state, name, 3), argOf[D](state, name, 4)))
+		}
+	}}
+}
+
+// --------------------------------------------------------------------
+
+// func0 creates a native function which receives the context and returns a value
+func func0[R Value](fn func(context.Context) (R, error)) Func {
+	sig := signatureFor()
+	return Func{func(name string) lua.LGFunction {
+		return func(state *lua.LState) int {
+			if !sig.verify(state, name) {
+				return 0
+			}
+
+			r, err := fn(contextOf(state))
+			return push(state, r, err)
+		}
+	}}
+}
+
+// func1 creates a native function which receives the context, takes one value and
+// returns a value
+func func1[A, R Value](fn func(context.Context, A) (R, error)) Func {
+	sig := signatureFor(optional[A]())
+	return Func{func(name string) lua.LGFunction {
+		return func(state *lua.LState) int {
+			if !sig.verify(state, name) {
+				return 0
+			}
+
+			r, err := fn(contextOf(state), argOf[A](state, name, 1))
+			return push(state, r, err)
+		}
+	}}
+}
+
+// action1 creates a native function which receives the context, takes one value and
+// returns nothing
+func action1[A Value](fn func(context.Context, A) error) Func {
+	sig := signatureFor(optional[A]())
+	return Func{func(name string) lua.LGFunction {
+		return func(state *lua.LState) int {
+			if !sig.verify(state, name) {
+				return 0
+			}
+
+			return check(state, fn(contextOf(state), argOf[A](state, name, 1)))
+		}
+	}}
+}
+
+// --------------------------------------------------------------------
+
+// typeOf returns the reflected type of a type parameter
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// optional returns whether a parameter can be omitted by the script, which is the
+// case of the Value interface since the missing arguments are nil.
+func optional[T Value]() bool {
+	var zero T
+	return any(zero) == nil
+}
+
+// argOf returns the i-th argument of the state. The scalars are coerced the same way
+// as the standard library does, such as a number passed as a string, and the tables
+// are converted without reflection, such as a table of numbers passed as an array.
+func argOf[T Value](state *lua.LState, name string, i int) (out T) {
+	v := state.Get(i)
+	switch p := any(&out).(type) {
+	case *String:
+		*p = String(state.CheckString(i))
+		return
+	case *Number:
+		*p = Number(state.CheckNumber(i))
+		return
+	case *Bool:
+		*p = Bool(state.CheckBool(i))
+		return
+	case *Array:
+		if t, ok := v.(*lua.LTable); ok {
+			if _, ok := sequenceOf(t); ok {
+				*p = asArrays(t)
+				return
+			}
+		}
+	case *Table:
+		if t, ok := v.(*lua.LTable); ok {
+			if _, ok := sequenceOf(t); !ok || t.Len() == 0 {
+				*p = asTable(t)
+				return
+			}
+		}
+	}
+
+	switch value := resultOf(v).(type) {
+	case T:
+		return value
+	case Nil: // Same as the reflection, nil and empty tables are converted to nil
+		switch any(&out).(type) {
+		case *Numbers, *Strings, *Bools, *Array, *Table:
+			return
+		}
+	}
+
+	// The argument can not be converted, the reflection is only used for the error
+	_, err := argumentOf(typeOf[T](), v)
+	if err == nil {
+		err = errors.New("invalid argument")
+	}
+
+	state.RaiseError("bad argument #%d to %s (%s)", i, name, err)
+	return
+}
+
+// push pushes the value returned by a native function, or raises its error
+func push[R Value](state *lua.LState, r R, err error) int {
+	if err != nil {
+		raise(state, err)
+		return 0
+	}
+
+	switch v := any(r).(type) {
+	case String:
+		state.Push(lua.LString(v))
+	case Number:
+		state.Push(lua.LNumber(v))
+	case Bool:
+		state.Push(lua.LBool(v))
+	default:
+		state.Push(lvalueOf(state, r))
+	}
+	return 1
+}
+
+// check raises the error returned by a native function, if any
+func check(state *lua.LState, err error) int {
+	if err != nil {
+		raise(state, err)
+	}
+	return 0
+}
@@ -0,0 +1,352 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFunc(t *testing.T) {
+	m := &NativeModule{Name: "test"}
+	assert.NoError(t, m.Register("sum", Func2(func(a Numbers, b Number) (Number, error) {
+		for _, v := range a {
+			b += Number(v)
+		}
+		return b, nil
+	})))
+	assert.NoError(t, m.Register("concat", Func3(func(a, b String, c Value) (String, error) {
+		if c.Type() == TypeNil {
+			return a + b, nil
+		}
+		return a + b + String(c.String()), nil
+	})))
+	assert.NoError(t, m.Register("size", Func4(func(a Table, b Array, c Strings, d Bools) (Number, error) {
+		return Number(len(a) + len(b) + len(c) + len(d)), nil
+	})))
+	assert.NoError(t, m.Register("keys", Func1(func(a Table) (Strings, error) {
+		out := make(Strings, 0, len(a))
+		for k := range a {
+			out = append(out, k)
+		}
+		return out, nil
+	})))
+	assert.NoError(t, m.Register("count", Func2(func(a Array, b Table) (Number, error) {
+		return Number(len(a)*10 + len(b)), nil
+	})))
+	assert.NoError(t, m.Register("fail", Action0(func() error {
+		return errors.New("boom")
+	})))
+	assert.NoError(t, m.Register("check", Action2(func(a String, b Bool) error {
+		if !b {
+			return errors.New(string(a))
+		}
+		return nil
+	})))
+
+	s, err := New("test.lua", strings.NewReader(`
+	local api = require("test")
+	function main(name)
+		if name == "sum" then
+			return api.sum({1, 2, 3}, 4)
+		elseif name == "concat" then
+			return api.concat("a", "b") .. api.concat("a", "b", "c")
+		elseif name == "size" then
+			return api.size({a = 1}, {1, 2}, {"a", "b", "c"}, {})
+		elseif name == "keys" then
+			return api.keys({a = 1})
+		elseif name == "check" then
+			return api.check("ok", true)
+		elseif name == "array" then
+			return api.count({1, 2}, {a = 1}) + api.count({"a", {}}, {}) + api.count({}, {})
+		elseif name == "nil" then
+			return api.size(nil, nil, nil, nil)
+		elseif name == "coerce" then
+			return api.sum({}, "7") .. api.concat(5, 6)
+		elseif name == "fail" then
+			return api.fail()
+		elseif name == "mismatch" then
+			return api.sum({1}, "x")
+		else
+			return api.concat("a")
+		end
+	end`), WithModules(m))
+	assert.NoError(t, err)
+
+	for name, expect := range map[string]Value{
+		"sum":    Number(10),
+		"concat": String("ababc"),
+		"size":   Number(6),
+		"keys":   Strings{"a"},
+		"check":  Nil{},
+		"array":  Number(41),
+		"coerce": String("756"),
+		"nil":    Number(0),
+	} {
+		out, err := s.Run(context.Background(), name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, expect, out, name)
+	}
+
+	for name, expect := range map[string]string{
+		"fail":     "boom",
+		"mismatch": "bad argument #2 to sum (number expected, got string)",
+		"missing":  "concat expects at least 2 arguments, but got 1",
+	} {
+		_, err := s.Run(context.Background(), name)
+		assert.ErrorContains(t, err, expect, name)
+	}
+
+	// A function must be created with the helpers
+	assert.Error(t, m.Register("empty", Func{}))
+}
+
+func TestFuncBuiltin(t *testing.T) {
+	for _, typ := range []any{
+		func(String) error { return nil },
+		func() (Number, error) { return 0, nil },
+		func(context.Context, Bool) error { return nil },
+		func(context.Context) (String, error) { return "", nil },
+		func(Number) (Bool, error) { return false, nil },
+		func(context.Context, Bool) (String, error) { return "", nil },
+	} {
+		_, ok := builtin[reflect.TypeOf(typ)]
+		assert.True(t, ok)
+	}
+
+	// Scalars are coerced, same as the standard library
+	m := &NativeModule{Name: "test"}
+	assert.NoError(t, m.Register("echo", func(v String) (String, error) {
+		return v, nil
+	}))
+
+	s, err := FromString("test.lua", `
+	local api = require("test")
+	function main()
+		return api.echo(5)
+	end`, m)
+	assert.NoError(t, err)
+
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, String("5"), out)
+}
+
+func Test_In(t *testing.T) {
+	t.Run("string", testIn[String])
+	t.Run("number", testIn[Number])
+	t.Run("bool", testIn[Bool])
+}
+
+func Test_Out(t *testing.T) {
+	t.Run("string", testOut[String])
+	t.Run("number", testOut[Number])
+	t.Run("bool", testOut[Bool])
+}
+
+func Test_InContext(t *testing.T) {
+	t.Run("string", testInContext[String])
+	t.Run("number", testInContext[Number])
+	t.Run("bool", testInContext[Bool])
+}
+
+func Test_OutContext(t *testing.T) {
+	t.Run("string", testOutContext[String])
+	t.Run("number", testOutContext[Number])
+	t.Run("bool", testOutContext[Bool])
+}
+
+func Test_InOut(t *testing.T) {
+	t.Run("string", testInOut[String, String])
+	t.Run("string-number", testInOut[String, Number])
+	t.Run("string-bool", testInOut[String, Bool])
+	t.Run("number-string", testInOut[Number, String])
+	t.Run("number", testInOut[Number, Number])
+	t.Run("number-bool", testInOut[Number, Bool])
+	t.Run("bool-string", testInOut[Bool, String])
+	t.Run("bool-number", testInOut[Bool, Number])
+	t.Run("bool", testInOut[Bool, Bool])
+}
+
+func Test_ContextInOut(t *testing.T) {
+	t.Run("string", testContextInOut[String, String])
+	t.Run("string-number", testContextInOut[String, Number])
+	t.Run("string-bool", testContextInOut[String, Bool])
+	t.Run("number-string", testContextInOut[Number, String])
+	t.Run("number", testContextInOut[Number, Number])
+	t.Run("number-bool", testContextInOut[Number, Bool])
+	t.Run("bool-string", testContextInOut[Bool, String])
+	t.Run("bool-number", testContextInOut[Bool, Number])
+	t.Run("bool", testContextInOut[Bool, Bool])
+}
+
+// --------------------------------------------------------------------
+
+func testValue[T Value]() T {
+	var zero T
+	return newTestValue(zero.Type()).(T)
+}
+
+func testIn[T Value](t *testing.T) {
+	m := &NativeModule{Name: "test"}
+	m.Register("test1", func(v T) error {
+		return nil
+	})
+	m.Register("test2", func(v T) error {
+		return errors.New("boom")
+	})
+
+	{ // Happy path
+		s, err := FromString("", `
+		local api = require("test")
+		function main(input)
+			return api.test1(input)
+		end`, m)
+		assert.NotNil(t, s)
+		assert.NoError(t, err)
+		_, err = s.Run(context.Background(), testValue[T]())
+		assert.NoError(t, err)
+	}
+
+	{ // Invalid argument
+		s, err := FromString("", `
+		local api = require("test")
+		function main(input)
+			return api.test2(input)
+		end`, m)
+		assert.NotNil(t, s)
+		assert.NoError(t, err)
+		_, err = s.Run(context.Background(), testValue[T]())
+		assert.Error(t, err)
+	}
+}
+
+func testOut[T Value](t *testing.T) {
+	m := &NativeModule{Name: "test"}
+	m.Register("test1", func() (T, error) {
+		return testValue[T](), nil
+	})
+	m.Register("test2", func() (T, error) {
+		return testValue[T](), errors.New("boom")
+	})
+
+	{ // Happy path
+		s, err := FromString("", `
+		local api = require("test")
+		function main(input)
+			return api.test1(input)
+		end`, m)
+		assert.NotNil(t, s)
+		assert.NoError(t, err)
+		_, err = s.Run(context.Background())
+		assert.NoError(t, err)
+	}
+
+	{ // Invalid argument
+		s, err := FromString("", `
+		local api = require("test")
+		function main(input)
+			return api.test2(input)
+		end`, m)
+		assert.NotNil(t, s)
+		assert.NoError(t, err)
+		_, err = s.Run(context.Background())
+		assert.Error(t, err)
+	}
+}
+
+func testInContext[T Value](t *testing.T) {
+	m := &NativeModule{Name: "test"}
+	m.Register("test1", func(ctx context.Context, v T) error {
+		return ctx.Value(testContextKey).(error)
+	})
+
+	s, err := FromString("", `
+	local api = require("test")
+	function main(input)
+		return api.test1(input)
+	end`, m)
+	assert.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), testContextKey, errors.New("boom"))
+	_, err = s.Run(ctx, testValue[T]())
+	assert.ErrorContains(t, err, "boom")
+}
+
+func testOutContext[T Value](t *testing.T) {
+	m := &NativeModule{Name: "test"}
+	m.Register("test1", func(ctx context.Context) (T, error) {
+		return ctx.Value(testContextKey).(T), nil
+	})
+
+	s, err := FromString("", `
+	local api = require("test")
+	function main()
+		return api.test1()
+	end`, m)
+	assert.NoError(t, err)
+
+	expect := testValue[T]()
+	out, err := s.Run(context.WithValue(context.Background(), testContextKey, expect))
+	assert.NoError(t, err)
+	assert.Equal(t, Value(expect), out)
+}
+
+func testInOut[A, R Value](t *testing.T) {
+	m := &NativeModule{Name: "test"}
+	m.Register("test1", func(v A) (R, error) {
+		return testValue[R](), nil
+	})
+	m.Register("test2", func(v A) (R, error) {
+		return testValue[R](), errors.New("boom")
+	})
+
+	{ // Happy path
+		s, err := FromString("", `
+		local api = require("test")
+		function main(input)
+			return api.test1(input)
+		end`, m)
+		assert.NotNil(t, s)
+		assert.NoError(t, err)
+		_, err = s.Run(context.Background(), testValue[A]())
+		assert.NoError(t, err)
+	}
+
+	{ // Invalid argument
+		s, err := FromString("", `
+		local api = require("test")
+		function main(input)
+			return api.test2(input)
+		end`, m)
+		assert.NotNil(t, s)
+		assert.NoError(t, err)
+		_, err = s.Run(context.Background(), testValue[A]())
+		assert.Error(t, err)
+	}
+}
+
+func testContextInOut[A, R Value](t *testing.T) {
+	m := &NativeModule{Name: "test"}
+	m.Register("test1", func(ctx context.Context, v A) (R, error) {
+		return ctx.Value(testContextKey).(R), nil
+	})
+
+	s, err := FromString("", `
+	local api = require("test")
+	function main(input)
+		return api.test1(input)
+	end`, m)
+	assert.NoError(t, err)
+
+	expect := testValue[R]()
+	out, err := s.Run(context.WithValue(context.Background(), testContextKey, expect), testValue[A]())
+	assert.NoError(t, err)
+	assert.Equal(t, Value(expect), out)
+}
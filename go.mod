@@ -3,7 +3,6 @@ module github.com/kelindar/lua
 go 1.20
 
 require (
-	github.com/cheekybits/genny v1.0.0
 	github.com/stretchr/testify v1.8.0
 	github.com/yuin/gopher-lua v1.1.1
 	layeh.com/gopher-luar v1.0.10
@@ -0,0 +1,102 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	lua "github.com/yuin/gopher-lua"
+)
+
+// snapshot represents a shallow copy of the global environment of a VM, which
+// consists of the globals table, the tables it contains directly, the table of the
+// loaded modules and the tables of these modules.
+type snapshot struct {
+	tables  []tableCopy  // The copies of the tables
+	removed []lua.LValue // The scratch space for the removed keys
+}
+
+// tableCopy represents a shallow copy of a table
+type tableCopy struct {
+	table     *lua.LTable        // The original table
+	metatable lua.LValue         // The original metatable
+	keys      []lua.LValue       // The original keys
+	values    []lua.LValue       // The original values
+	index     map[lua.LValue]int // The index of the keys
+}
+
+// newSnapshot takes a snapshot of the global environment of the state
+func newSnapshot(state *lua.LState) *snapshot {
+	s := new(snapshot)
+	seen := make(map[*lua.LTable]bool, 32)
+	s.add(state.G.Global, seen)
+	state.G.Global.ForEach(func(_, v lua.LValue) {
+		if t, ok := v.(*lua.LTable); ok {
+			s.add(t, seen)
+		}
+	})
+
+	// Add the table of the loaded modules, so the modules first loaded during a run
+	// are unloaded, and the modules which were loaded but are not in the globals
+	if loaded, ok := state.GetField(state.G.Registry, "_LOADED").(*lua.LTable); ok {
+		s.add(loaded, seen)
+		loaded.ForEach(func(_, v lua.LValue) {
+			if t, ok := v.(*lua.LTable); ok {
+				s.add(t, seen)
+			}
+		})
+	}
+
+	// Add the preloaded modules, so the loaders registered during a run are removed
+	if preload, ok := state.GetField(state.GetGlobal(lua.LoadLibName), "preload").(*lua.LTable); ok {
+		s.add(preload, seen)
+	}
+	return s
+}
+
+// add adds a shallow copy of the table to the snapshot
+func (s *snapshot) add(table *lua.LTable, seen map[*lua.LTable]bool) {
+	if seen[table] {
+		return
+	}
+
+	seen[table] = true
+	c := tableCopy{
+		table:     table,
+		metatable: table.Metatable,
+		index:     make(map[lua.LValue]int, 16),
+	}
+
+	table.ForEach(func(k, v lua.LValue) {
+		c.index[k] = len(c.keys)
+		c.keys = append(c.keys, k)
+		c.values = append(c.values, v)
+	})
+	s.tables = append(s.tables, c)
+}
+
+// restore restores all of the tables to their original state
+func (s *snapshot) restore() {
+	for i := range s.tables {
+		c := &s.tables[i]
+
+		// Remove the keys which were added since the snapshot
+		s.removed = s.removed[:0]
+		c.table.ForEach(func(k, _ lua.LValue) {
+			if _, ok := c.index[k]; !ok {
+				s.removed = append(s.removed, k)
+			}
+		})
+		for _, k := range s.removed {
+			c.table.RawSet(k, lua.LNil)
+		}
+
+		// Restore the values which were modified or removed
+		for i, k := range c.keys {
+			if c.table.RawGet(k) != c.values[i] {
+				c.table.RawSet(k, c.values[i])
+			}
+		}
+
+		c.table.Metatable = c.metatable
+	}
+}
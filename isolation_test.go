@@ -0,0 +1,128 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const leaky = `
+local json = require("json")
+counter = 0
+
+function main()
+	counter = counter + 1
+	leaked = (leaked or 0) + 1
+	string.upper = nil
+	json.encode = nil
+	setmetatable(_G, {__index = function() return "meta" end})
+	return counter + leaked
+end
+
+function check()
+	return type(string.upper), type(json.encode), undefined
+end
+
+function fail()
+	leaked = 100
+	error("boom")
+end`
+
+/*
+cpu: Intel(R) Xeon(R) Processor @ 2.10GHz
+Benchmark_Isolation/default         	 5424763	       205.9 ns/op	       0 B/op	       0 allocs/op
+Benchmark_Isolation/isolated        	  104168	     11451 ns/op	    2352 B/op	     147 allocs/op
+*/
+func Benchmark_Isolation(b *testing.B) {
+	b.Run("default", func(b *testing.B) {
+		s, _ := newScript("fixtures/empty.lua")
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s.Run(context.Background())
+		}
+	})
+
+	b.Run("isolated", func(b *testing.B) {
+		s, _ := New("test.lua", strings.NewReader(`function main() end`), WithIsolation())
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s.Run(context.Background())
+		}
+	})
+}
+
+func TestIsolation(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(leaky),
+		WithIsolation(),
+		WithConcurrency(1),
+	)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		out, err := s.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, Number(2), out)
+	}
+
+	// Failed runs must be isolated too
+	_, err = s.Call(context.Background(), "fail")
+	assert.Error(t, err)
+
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Number(2), out)
+
+	// Modules and metatables must be restored
+	multi, err := s.CallMulti(context.Background(), "check")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{String("function"), String("function"), Nil{}}, multi)
+
+	// Modules first required during a run must be unloaded
+	module, err := FromString("secret", `
+	return { x = "public" }`)
+	assert.NoError(t, err)
+
+	s, err = New("test.lua", strings.NewReader(`
+	function main(value)
+		local m = require("secret")
+		local prev, other = m.x, package.preload["other"]
+		m.x = value
+		package.preload["other"] = function() return {} end
+		return prev, type(other)
+	end`),
+		WithModules(&ScriptModule{Script: module, Name: "secret"}),
+		WithIsolation(),
+		WithConcurrency(1),
+	)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		multi, err := s.RunMulti(context.Background(), "secret")
+		assert.NoError(t, err)
+		assert.Equal(t, []Value{String("public"), String("nil")}, multi)
+	}
+}
+
+func TestNoIsolation(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(leaky),
+		WithConcurrency(1),
+	)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		out, err := s.Run(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, Number(i*2), out)
+	}
+
+	multi, err := s.CallMulti(context.Background(), "check")
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{String("nil"), String("nil"), String("meta")}, multi)
+}
@@ -0,0 +1,163 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ErrMemoryLimit is returned when a run exceeds the memory limit of the VM
+var ErrMemoryLimit = errors.New("lua: memory limit exceeded")
+
+// MemoryError is returned when a run is aborted because the estimated memory
+// used by the VM exceeds its limit. It matches ErrMemoryLimit.
+type MemoryError struct {
+	Limit uint64 // The maximum number of bytes allowed
+	Used  uint64 // The estimated number of bytes used
+}
+
+// Error returns the error message
+func (e *MemoryError) Error() string {
+	return fmt.Sprintf("%s (%d of %d bytes)", ErrMemoryLimit.Error(), e.Used, e.Limit)
+}
+
+// Is returns whether the error matches the target error
+func (e *MemoryError) Is(target error) bool {
+	return target == ErrMemoryLimit
+}
+
+// --------------------------------------------------------------------
+
+// Approximate sizes of the values, in bytes
+const (
+	sizeString   = 16
+	sizeTable    = 64
+	sizeEntry    = 32
+	sizeFunction = 64
+	sizeUserData = 48
+	sizeThread   = 256
+)
+
+// sizer estimates the memory used by a state, by walking all of the values which
+// are reachable from the globals, the registry and the call stacks, including the
+// ones of the coroutines.
+type sizer struct {
+	seen    map[unsafe.Pointer]struct{} // The objects already visited
+	objects int                         // The number of objects visited
+}
+
+// sizeOf estimates the number of bytes used by the values of the state
+func (z *sizer) sizeOf(state *lua.LState) (size uint64) {
+	if z.seen == nil {
+		z.seen = make(map[unsafe.Pointer]struct{}, 256)
+	}
+
+	for k := range z.seen {
+		delete(z.seen, k)
+	}
+
+	z.objects = 0
+	size += z.value(state.G.Global)
+	size += z.value(state.G.Registry)
+	size += z.stack(state)
+
+	// Walk the coroutines being resumed, since the run may be executing one of them
+	for thread := state.G.CurrentThread; thread != nil && thread != state; thread = thread.Parent {
+		size += z.value(thread)
+	}
+	return
+}
+
+// stack estimates the number of bytes used by the call stack of a thread
+func (z *sizer) stack(state *lua.LState) (size uint64) {
+	for level := 0; ; level++ {
+		dbg, ok := state.GetStack(level)
+		if !ok {
+			break
+		}
+
+		if fn, err := state.GetInfo("f", dbg, lua.LNil); err == nil {
+			size += z.value(fn)
+		}
+
+		for i := 1; ; i++ {
+			name, v := state.GetLocal(dbg, i)
+			if name == "" {
+				break
+			}
+			size += z.value(v)
+		}
+	}
+	return
+}
+
+// value estimates the number of bytes used by a value
+func (z *sizer) value(v lua.LValue) (size uint64) {
+	switch v := v.(type) {
+	case lua.LString:
+		if len(v) >= 64 && !z.visit(unsafe.Pointer(unsafe.StringData(string(v)))) {
+			return 0 // Large strings are often shared, count them once
+		}
+		return sizeString + uint64(len(v))
+	case *lua.LTable:
+		if !z.visit(unsafe.Pointer(v)) {
+			return 0
+		}
+
+		size = sizeTable
+		v.ForEach(func(k, v lua.LValue) {
+			size += sizeEntry + z.value(k) + z.value(v)
+		})
+		if v.Metatable != nil {
+			size += z.value(v.Metatable)
+		}
+		return
+	case *lua.LFunction:
+		if !z.visit(unsafe.Pointer(v)) {
+			return 0
+		}
+
+		size = sizeFunction
+		for _, up := range v.Upvalues {
+			size += z.value(up.Value())
+		}
+		if v.Env != nil {
+			size += z.value(v.Env)
+		}
+		return
+	case *lua.LState:
+		if !z.visit(unsafe.Pointer(v)) {
+			return 0
+		}
+
+		return sizeThread + z.stack(v)
+	case *lua.LUserData:
+		if !z.visit(unsafe.Pointer(v)) {
+			return 0
+		}
+
+		size = sizeUserData
+		if v.Metatable != nil {
+			size += z.value(v.Metatable)
+		}
+		return
+	default:
+		return 0
+	}
+}
+
+// visit marks the object as visited, returning false if it already was
+func (z *sizer) visit(ptr unsafe.Pointer) bool {
+	if _, ok := z.seen[ptr]; ok {
+		return false
+	}
+
+	z.seen[ptr] = struct{}{}
+	z.objects++
+	return true
+}
@@ -0,0 +1,143 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	counter = 0
+
+	function main()
+		counter = counter + 1
+		return counter
+	end
+
+	function grow()
+		local t = {}
+		for i = 1, 1e8 do
+			t[i] = "item " .. i
+		end
+	end`), WithMemoryLimit(1<<20), WithConcurrency(1))
+	assert.NoError(t, err)
+
+	// Mutate the state of the VM
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Number(1), out)
+
+	_, err = s.Call(context.Background(), "grow")
+	assert.ErrorIs(t, err, ErrMemoryLimit)
+
+	var memErr *MemoryError
+	assert.True(t, errors.As(err, &memErr))
+	assert.Equal(t, uint64(1<<20), memErr.Limit)
+	assert.Greater(t, memErr.Used, memErr.Limit)
+
+	// The VM must have been replaced with a fresh one
+	out, err = s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Number(1), out)
+}
+
+func TestMemoryLimitCoroutines(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	local function grow()
+		local t = {}
+		for i = 1, 200000 do
+			t[i] = i
+		end
+		return #t
+	end
+
+	function main(name)
+		if name == "wrap" then
+			return coroutine.wrap(grow)()
+		elseif name == "suspended" then
+			local co = coroutine.wrap(function()
+				local t = {}
+				for i = 1, 200000 do
+					t[i] = i
+				end
+				coroutine.yield(#t)
+				return #t
+			end)
+			co()
+			for i = 1, 10000 do end
+			return 0
+		else
+			local ok, err = coroutine.resume(coroutine.create(grow))
+			return err
+		end
+	end`), WithMemoryLimit(100000))
+	assert.NoError(t, err)
+
+	for _, name := range []string{"wrap", "suspended", "resume"} {
+		_, err := s.Run(context.Background(), name)
+		assert.ErrorIs(t, err, ErrMemoryLimit, name)
+	}
+}
+
+func TestMemoryLimitString(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	function main()
+		local s = "x"
+		for i = 1, 1e6 do
+			s = s .. "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"
+		end
+		return #s
+	end`), WithMemoryLimit(1<<18))
+	assert.NoError(t, err)
+
+	_, err = s.Run(context.Background())
+	assert.ErrorIs(t, err, ErrMemoryLimit)
+}
+
+func TestMemoryWithinLimit(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	function main(n)
+		local t = {}
+		for i = 1, n do
+			t[i] = i
+		end
+		return #t
+	end`), WithMemoryLimit(1<<20))
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		out, err := s.Run(context.Background(), 1000)
+		assert.NoError(t, err)
+		assert.Equal(t, Number(1000), out)
+	}
+}
+
+func TestSizeOf(t *testing.T) {
+	state := lua.NewState()
+	defer state.Close()
+
+	var z sizer
+	base := z.sizeOf(state)
+	assert.Greater(t, base, uint64(0))
+
+	// Add a table with strings into globals
+	tbl := state.NewTable()
+	for i := 0; i < 100; i++ {
+		tbl.Append(lua.LString("hello"))
+	}
+	state.SetGlobal("tbl", tbl)
+	assert.Equal(t, base+sizeEntry+z.value(lua.LString("tbl"))+
+		sizeTable+100*(sizeEntry+sizeString+5), z.sizeOf(state))
+
+	// Cycles must only be counted once
+	tbl.RawSetString("self", tbl)
+	assert.Greater(t, z.sizeOf(state), base)
+}
@@ -7,18 +7,20 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"runtime/debug"
 	"sync"
 
 	lua "github.com/yuin/gopher-lua"
 )
 
 var (
-	errFuncInput   = errors.New("lua: function input arguments must be of type lua.Value")
-	errFuncOutput  = errors.New("lua: function return values must be either (error) or (lua.Value, error)")
+	errFuncInput   = errors.New("lua: function input arguments must be of type lua.Value or a convertible Go type, optionally preceded by a context.Context")
+	errFuncOutput  = errors.New("lua: function return values must be zero or more lua.Value or convertible Go types followed by an error")
+	errFuncEmpty   = errors.New("lua: function must be created with one of the FuncN or ActionN helpers")
 	errorInterface = reflect.TypeOf((*error)(nil)).Elem()
 )
 
-var builtin = make(map[reflect.Type]func(any) lua.LGFunction, 8)
+var builtin = make(map[reflect.Type]func(any) Func, 32)
 
 // Module represents a loadable module.
 type Module interface {
@@ -38,12 +40,12 @@ type ScriptModule struct {
 func (m *ScriptModule) inject(runtime *lua.LState) error {
 
 	// Inject the prerequisite modules of the module
-	if err := m.Script.loadModules(runtime); err != nil {
+	if err := m.Script.injectModules(runtime); err != nil {
 		return err
 	}
 
 	// Push the function to the runtime
-	codeFn := runtime.NewFunctionFromProto(m.Script.code)
+	codeFn := runtime.NewFunctionFromProto(m.Script.version.Load().code)
 	preload := runtime.GetField(runtime.GetField(runtime.Get(lua.EnvironIndex), "package"), "preload")
 	if _, ok := preload.(*lua.LTable); !ok {
 		return errors.New("package.preload must be a table")
@@ -68,44 +70,145 @@ type fngen struct {
 	code any
 }
 
-// Generate generates a function
-func (g *fngen) generate() lua.LGFunction {
+// Generate generates a function, which recovers its panics on behalf of the VM
+func (g *fngen) generate(owner *vm) lua.LGFunction {
+	return protect(owner, g.name, g.compile())
+}
+
+// compile compiles the function into a LUA function
+func (g *fngen) compile() lua.LGFunction {
+	if fn, ok := g.code.(Func); ok {
+		return fn.compile(g.name)
+	}
+
 	rv := reflect.ValueOf(g.code)
 	rt := rv.Type()
 	if maker, ok := builtin[rt]; ok {
-		return maker(g.code)
+		return maker(g.code).compile(g.name)
 	}
 
 	name := g.name
+	sig := signatureOf(rt)
 	args := make([]reflect.Value, 0, rt.NumIn())
 	return func(state *lua.LState) int {
-		if state.GetTop() != rt.NumIn() {
-			state.RaiseError("%s expects %d arguments, but got %d", name, rt.NumIn(), state.GetTop())
+		if !sig.verify(state, name) {
 			return 0
 		}
 
-		// Convert the arguments
+		// Convert the arguments, the missing optional ones are nil
 		args = args[:0]
-		for i := 0; i < rt.NumIn(); i++ {
-			args = append(args, reflect.ValueOf(resultOf(state.Get(i+1))))
+		if sig.context {
+			args = append(args, reflect.ValueOf(contextOf(state)))
 		}
-
-		// Call the function
-		out := rv.Call(args)
-		switch len(out) {
-		case 1:
-			if err := out[0]; !err.IsNil() {
-				state.RaiseError(err.Interface().(error).Error())
+		n := state.GetTop()
+		for i := 0; i < n || i < sig.fixed; i++ {
+			if i >= n {
+				args = append(args, nilValue)
+				continue
 			}
-			return 0
-		default:
-			if err := out[1]; !err.IsNil() {
-				state.RaiseError(err.Interface().(error).Error())
+
+			arg, err := argumentOf(sig.param(rt, i), state.Get(i+1))
+			if err != nil {
+				state.RaiseError("bad argument #%d to %s (%s)", i+1, name, err)
 				return 0
 			}
-			state.Push(lvalueOf(state, out[0].Interface()))
-			return 1
+			args = append(args, arg)
 		}
+
+		// Call the function, the error is always the last return value
+		out := rv.Call(args)
+		if err := out[len(out)-1]; !err.IsNil() {
+			raise(state, err.Interface().(error))
+			return 0
+		}
+
+		// Push all of the returned values, converting the plain Go types
+		for _, v := range out[:len(out)-1] {
+			state.Push(ValueOf(v.Interface()).lvalue(state))
+		}
+		return len(out) - 1
+	}
+}
+
+// nilValue is the value of the missing optional arguments
+var nilValue = reflect.ValueOf(Nil{})
+
+// signature represents the parameters of a native function, as seen by the script
+type signature struct {
+	context  bool // Whether the function receives the context first
+	variadic bool // Whether the function accepts any number of extra arguments
+	fixed    int  // The number of parameters passed by the script, except variadic ones
+	required int  // The number of parameters which must be passed by the script
+}
+
+// signatureOf returns the signature of a native function. The trailing parameters
+// of type Value are optional, since they can be nil.
+func signatureOf(rt reflect.Type) (sig signature) {
+	sig.context = hasContext(rt)
+	sig.variadic = rt.IsVariadic()
+	sig.fixed = rt.NumIn()
+	if sig.context {
+		sig.fixed--
+	}
+	if sig.variadic {
+		sig.fixed--
+	}
+
+	first := rt.NumIn() - sig.fixed
+	if sig.variadic {
+		first--
+	}
+
+	sig.required = sig.fixed
+	for sig.required > 0 && rt.In(first+sig.required-1) == typeValue {
+		sig.required--
+	}
+	return
+}
+
+// signatureFor returns the signature of a typed native function, given whether each
+// of its parameters is optional. The extra arguments are ignored, as for LUA functions.
+func signatureFor(optional ...bool) signature {
+	sig := signature{variadic: true, fixed: len(optional), required: len(optional)}
+	for sig.required > 0 && optional[sig.required-1] {
+		sig.required--
+	}
+	return sig
+}
+
+// param returns the type of the i-th parameter passed by the script
+func (sig *signature) param(rt reflect.Type, i int) reflect.Type {
+	if sig.context {
+		i++
+	}
+
+	if sig.variadic && i >= rt.NumIn()-1 {
+		return rt.In(rt.NumIn() - 1).Elem()
+	}
+	return rt.In(i)
+}
+
+// verify verifies the number of arguments on the state, raising an error if invalid
+func (sig *signature) verify(state *lua.LState, name string) bool {
+	n := state.GetTop()
+	if err := sig.check(n); err != "" {
+		state.RaiseError("%s expects %s, but got %d", name, err, n)
+		return false
+	}
+	return true
+}
+
+// check checks the number of arguments and describes the expected ones if invalid
+func (sig *signature) check(n int) string {
+	switch {
+	case n >= sig.required && (n <= sig.fixed || sig.variadic):
+		return ""
+	case sig.variadic:
+		return fmt.Sprintf("at least %d arguments", sig.required)
+	case sig.required == sig.fixed:
+		return fmt.Sprintf("%d arguments", sig.fixed)
+	default:
+		return fmt.Sprintf("%d to %d arguments", sig.required, sig.fixed)
 	}
 }
 
@@ -137,9 +240,10 @@ func (m *NativeModule) Unregister(name string) {
 
 // Inject loads the module into the state
 func (m *NativeModule) inject(state *lua.LState) error {
+	owner := vmOf(state)
 	table := make(map[string]lua.LGFunction, len(m.funcs))
 	for name, g := range m.funcs {
-		table[name] = g.generate()
+		table[name] = g.generate(owner)
 	}
 
 	state.PreloadModule(m.Name, func(state *lua.LState) int {
@@ -151,40 +255,79 @@ func (m *NativeModule) inject(state *lua.LState) error {
 	return nil
 }
 
+// protect recovers the panics of a native function and raises them as a *PanicError
+// instead, marking the VM so that it is replaced once the run completes.
+func protect(owner *vm, name string, fn lua.LGFunction) lua.LGFunction {
+	return func(state *lua.LState) int {
+		defer func() {
+			switch r := recover(); r.(type) {
+			case nil:
+			case *lua.ApiError: // The errors raised by the function
+				panic(r)
+			default:
+				if owner != nil {
+					owner.broken = true
+				}
+
+				raise(state, &PanicError{
+					Function: name,
+					Value:    r,
+					Stack:    string(debug.Stack()),
+				})
+			}
+		}()
+		return fn(state)
+	}
+}
+
 // validate validates the function type
 func validate(function any) error {
+	if fn, ok := function.(Func); ok {
+		if fn.compile == nil {
+			return errFuncEmpty
+		}
+		return nil
+	}
+
 	rv := reflect.ValueOf(function)
 	rt := rv.Type()
 	if rt.Kind() != reflect.Func {
 		return fmt.Errorf("lua: input is a %s, not a function", rt.Kind().String())
 	}
 
-	// Validate the input
+	// Validate the input, which may start with a context and end with variadic values
 	for i := 0; i < rt.NumIn(); i++ {
-		if _, ok := typeMap[rt.In(i)]; !ok {
+		in := rt.In(i)
+		if rt.IsVariadic() && i == rt.NumIn()-1 {
+			in = in.Elem()
+		}
+
+		if !isConvertible(in) && !(i == 0 && hasContext(rt)) {
 			return errFuncInput
 		}
 	}
 
-	// Validate the output
-	switch {
-	case rt.NumOut() == 1 && isError(rt, 0):
-	case rt.NumOut() == 2 && isValid(rt, 0) && isError(rt, 1):
-	default:
+	// Validate the output, which must end with an error
+	if rt.NumOut() == 0 || !isError(rt, rt.NumOut()-1) {
 		return errFuncOutput
 	}
+	for i := 0; i < rt.NumOut()-1; i++ {
+		if !isValid(rt, i) {
+			return errFuncOutput
+		}
+	}
 	return nil
 }
 
+// hasContext returns whether the first parameter of the function is a context
+func hasContext(rt reflect.Type) bool {
+	return rt.NumIn() > 0 && rt.In(0) == typeContext
+}
+
 func isError(rt reflect.Type, at int) bool {
 	return rt.Out(at).Implements(typeError)
 }
 
 func isValid(rt reflect.Type, at int) bool {
-	switch rt.Out(at) {
-	case typeString, typeNumber, typeBool, typeNumbers, typeStrings, typeBools, typeTable, typeArray, typeValue:
-		return true
-	default:
-		return false
-	}
+	return isConvertible(rt.Out(at))
 }
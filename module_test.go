@@ -32,6 +32,7 @@ func testModule() Module {
 	must(m.Register("error1", errorfunc1))
 	must(m.Register("toNumbers", toNumbers))
 	must(m.Register("value", value))
+	must(m.Register("divmod", divmod))
 	return m
 }
 
@@ -94,6 +95,13 @@ func value(v Value) (Value, error) {
 	return v, nil
 }
 
+func divmod(a, b Number) (Number, Number, error) {
+	if b == 0 {
+		return 0, 0, errors.New("division by zero")
+	}
+	return Number(int(a) / int(b)), Number(int(a) % int(b)), nil
+}
+
 func Test_Join(t *testing.T) {
 	s, err := newScript("fixtures/join.lua")
 	assert.NoError(t, err)
@@ -125,7 +133,7 @@ func Test_Sum(t *testing.T) {
 }
 
 func Test_JoinMap(t *testing.T) {
-	s, err := newScript("fixtures/joinMap.lua")
+	s, err := newScript("fixtures/joinmap.lua")
 	assert.NoError(t, err)
 
 	out, err := s.Run(context.Background(), map[string]any{
@@ -255,3 +263,264 @@ func Test_Any(t *testing.T) {
 	assert.Equal(t, TypeNumbers, out.Type())
 	assert.Equal(t, []float64{1.1, 2.1}, out.Native())
 }
+
+func TestMultipleReturns(t *testing.T) {
+	s, err := newScript("fixtures/divmod.lua")
+	assert.NoError(t, err)
+
+	out, err := s.RunMulti(context.Background(), 17, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{Number(3), Number(2)}, out)
+
+	_, err = s.RunMulti(context.Background(), 17, 0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "division by zero")
+}
+
+func TestInvalidReturns(t *testing.T) {
+	m := &NativeModule{Name: "test"}
+	assert.Error(t, m.Register("none", func(Number) {}))
+	assert.Error(t, m.Register("noerr", func(Number) Number { return 0 }))
+	assert.Error(t, m.Register("order", func(Number) (error, Number) { return nil, 0 }))
+	assert.NoError(t, m.Register("three", func(Number) (Number, String, Bool, error) {
+		return 0, "", false, nil
+	}))
+}
+
+type testContextType string
+
+const testContextKey = testContextType("test")
+
+func TestContextArgument(t *testing.T) {
+	m := &NativeModule{Name: "test"}
+	assert.NoError(t, m.Register("lookup", func(ctx context.Context, a, b Number) (String, Bool, error) {
+		_, wrapped := ctx.(*budget)
+		return ctx.Value(testContextKey).(String), Bool(wrapped), ctx.Err()
+	}))
+
+	s, err := New("test.lua", strings.NewReader(`
+	local api = require("test")
+	function main()
+		return api.lookup(1, 2)
+	end`), WithModules(m), WithBudget(1000))
+	assert.NoError(t, err)
+
+	// The context of the run is passed, rather than the budget
+	ctx := context.WithValue(context.Background(), testContextKey, String("acme"))
+	out, err := s.RunMulti(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{String("acme"), Bool(false)}, out)
+
+	// The context must be the first argument
+	assert.Error(t, m.Register("invalid", func(a Number, ctx context.Context) (String, error) {
+		return "", nil
+	}))
+}
+
+func TestVariadicArguments(t *testing.T) {
+	m := &NativeModule{Name: "test"}
+	assert.NoError(t, m.Register("sprintf", func(format String, args ...Value) (String, error) {
+		values := make([]any, 0, len(args))
+		for _, v := range args {
+			values = append(values, v.Native())
+		}
+		return String(fmt.Sprintf(string(format), values...)), nil
+	}))
+	assert.NoError(t, m.Register("default", func(a String, b Value) (String, error) {
+		if b.Type() == TypeNil {
+			return a + "!", nil
+		}
+		return a + String(b.String()), nil
+	}))
+
+	s, err := New("test.lua", strings.NewReader(`
+	local api = require("test")
+	function main(name)
+		if name == "sprintf" then
+			return api.sprintf("%s=%v", "a", 1)
+		elseif name == "none" then
+			return api.sprintf("none")
+		elseif name == "missing" then
+			return api.default("hi")
+		elseif name == "given" then
+			return api.default("hi", "?")
+		elseif name == "few" then
+			return api.sprintf()
+		else
+			return api.default("a", "b", "c")
+		end
+	end`), WithModules(m))
+	assert.NoError(t, err)
+
+	for name, expect := range map[string]Value{
+		"sprintf": String("a=1"),
+		"none":    String("none"),
+		"missing": String("hi!"),
+		"given":   String("hi?"),
+	} {
+		out, err := s.Run(context.Background(), name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, expect, out, name)
+	}
+
+	// Too few or too many arguments
+	_, err = s.Run(context.Background(), "few")
+	assert.ErrorContains(t, err, "sprintf expects at least 1 arguments, but got 0")
+	_, err = s.Run(context.Background(), "many")
+	assert.ErrorContains(t, err, "default expects 1 to 2 arguments, but got 3")
+}
+
+type testPoint struct {
+	X     int64  `json:"x"`
+	Y     int64  `json:"y"`
+	Label string `json:"label"`
+}
+
+func TestPlainArguments(t *testing.T) {
+	m := &NativeModule{Name: "test"}
+	assert.NoError(t, m.Register("repeat", func(s string, n int64) (string, error) {
+		return strings.Repeat(s, int(n)), nil
+	}))
+	assert.NoError(t, m.Register("join", func(v []string, sep string) (string, error) {
+		return strings.Join(v, sep), nil
+	}))
+	assert.NoError(t, m.Register("keys", func(v map[string]any) (int, error) {
+		return len(v), nil
+	}))
+	assert.NoError(t, m.Register("move", func(p testPoint, dx int8) (testPoint, error) {
+		p.X += int64(dx)
+		return p, nil
+	}))
+	assert.NoError(t, m.Register("split", func(s string) ([]string, error) {
+		return strings.Split(s, ","), nil
+	}))
+	assert.NoError(t, m.Register("labels", func(p []testPoint) (string, error) {
+		out := make([]string, 0, len(p))
+		for _, v := range p {
+			out = append(out, v.Label)
+		}
+		return strings.Join(out, ","), nil
+	}))
+
+	s, err := New("test.lua", strings.NewReader(`
+	local api = require("test")
+	function main(name)
+		if name == "repeat" then
+			return api["repeat"]("ab", 3)
+		elseif name == "join" then
+			return api.join({"a", "b"}, "-")
+		elseif name == "keys" then
+			return api.keys({a = 1, b = "x"})
+		elseif name == "move" then
+			local p = api.move({x = 1, y = 2, label = "p"}, 5)
+			return p.label .. ":" .. p.x .. "," .. p.y
+		elseif name == "split" then
+			return #api.split("a,b,c")
+		elseif name == "empty" then
+			return api.move({}, 1).x
+		elseif name == "labels" then
+			return api.labels({{}, {label = "b"}})
+		elseif name == "nil" then
+			return api.move(nil, 1)
+		elseif name == "fraction" then
+			return api["repeat"]("ab", 1.5)
+		elseif name == "range" then
+			return api.move({x = 1}, 1000)
+		elseif name == "mismatch" then
+			return api.join({"a"}, 1)
+		else
+			return api.join({"a", 1}, "")
+		end
+	end`), WithModules(m))
+	assert.NoError(t, err)
+
+	for name, expect := range map[string]Value{
+		"repeat": String("ababab"),
+		"join":   String("a-b"),
+		"keys":   Number(2),
+		"move":   String("p:6,2"),
+		"split":  Number(3),
+		"empty":  Number(1),
+		"labels": String(",b"),
+	} {
+		out, err := s.Run(context.Background(), name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, expect, out, name)
+	}
+
+	for name, expect := range map[string]string{
+		"fraction": "bad argument #2 to repeat (number has no integer representation)",
+		"range":    "bad argument #2 to move (number is out of range of int8)",
+		"mismatch": "bad argument #2 to join (string expected, got number)",
+		"element":  "bad argument #1 to join (element #2: string expected, got number)",
+		"nil":      "bad argument #1 to move (lua.testPoint expected, got nil)",
+	} {
+		_, err := s.Run(context.Background(), name)
+		assert.ErrorContains(t, err, expect, name)
+	}
+
+	// Channels cannot be converted
+	assert.Error(t, m.Register("invalid", func(chan int) error { return nil }))
+	assert.Error(t, m.Register("invalid", func() (chan int, error) { return nil, nil }))
+}
+
+func TestOverrideJSON(t *testing.T) {
+	m := &NativeModule{Name: "json"}
+	assert.NoError(t, m.Register("mine", func() (String, error) {
+		return "mine", nil
+	}))
+
+	s, err := New("test.lua", strings.NewReader(`
+	local json = require("json")
+	function main()
+		return json.mine()
+	end`), WithModules(m))
+	assert.NoError(t, err)
+
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, String("mine"), out)
+}
+
+func TestOverrideContext(t *testing.T) {
+	m := &NativeModule{Name: "context"}
+	assert.NoError(t, m.Register("mine", func() (String, error) {
+		return "mine", nil
+	}))
+
+	s, err := New("test.lua", strings.NewReader(`
+	local context = require("context")
+	function main()
+		return context.mine()
+	end`), WithModules(m))
+	assert.NoError(t, err)
+
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, String("mine"), out)
+}
+
+func TestMixedArgument(t *testing.T) {
+	m := &NativeModule{Name: "test"}
+	assert.NoError(t, m.Register("keys", func(v map[string]any) (int, error) {
+		return len(v), nil
+	}))
+
+	s, err := New("test.lua", strings.NewReader(`
+	local api = require("test")
+	function main()
+		return api.keys({1, x = "a"})
+	end
+	function mixed()
+		return {1, x = "a"}
+	end`), WithModules(m))
+	assert.NoError(t, err)
+
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Number(1), out)
+
+	out, err = s.Call(context.Background(), "mixed")
+	assert.NoError(t, err)
+	assert.Equal(t, Table{"x": String("a")}, out)
+}
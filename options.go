@@ -0,0 +1,228 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Option represents a configuration option for the script
+type Option func(*config)
+
+// config represents the configuration of the script and its VMs
+type config struct {
+	concurrency  int            // The maximum number of VMs in the pool
+	minimum      int            // The minimum number of VMs in the pool
+	idleTimeout  time.Duration  // The time after which idle VMs are evicted, or 0
+	callStack    int            // The maximum call stack size of each VM
+	registrySize int            // The initial size of the registry
+	registryMax  int            // The maximum size the registry can grow to
+	registryStep int            // The step by which the registry grows
+	modules      []Module       // The injected modules
+	sandbox      Sandbox        // The allowed standard libraries, or nil for all
+	budget       uint64         // The maximum number of instructions per run, or 0
+	memory       uint64         // The approximate maximum memory per VM, or 0
+	queue        int            // The maximum number of runs waiting for a VM, or 0
+	isolate      bool           // Whether the globals are restored after every run
+	history      int            // The number of versions kept for rollback
+	limit        *limiter       // The limit of VMs shared across scripts, or nil
+	config       Value          // The configuration passed to the init function
+	globals      map[string]any // The globals installed before the script runs
+	values       map[string]any // The context keys readable by the script, by name
+}
+
+// newConfig creates a new configuration with the default settings and the
+// options applied on top of them.
+func newConfig(options []Option) config {
+	c := config{
+		concurrency:  defaultConcurrency,
+		minimum:      -1,
+		callStack:    64,
+		registrySize: 1024 * 4,
+		registryMax:  1024 * 128,
+		registryStep: 32,
+		history:      8,
+	}
+
+	for _, opt := range options {
+		opt(&c)
+	}
+
+	if c.history <= 0 {
+		c.history = 1
+	}
+
+	if c.concurrency <= 0 {
+		c.concurrency = defaultConcurrency
+	}
+
+	// Unless specified, the pool is created with all of its VMs
+	if c.minimum < 0 || c.minimum > c.concurrency {
+		c.minimum = c.concurrency
+	}
+	return c
+}
+
+// newState creates a new LUA state with the configured limits and libraries
+func (c *config) newState() *lua.LState {
+	state := lua.NewState(lua.Options{
+		RegistrySize:        c.registrySize,   // this is the initial size of the registry
+		RegistryMaxSize:     c.registryMax,    // this is the maximum size that the registry can grow to. If set to `0` (the default) then the registry will not auto grow
+		RegistryGrowStep:    c.registryStep,   // this is how much to step up the registry by each time it runs out of space. The default is `32`.
+		CallStackSize:       c.callStack,      // this is the maximum callstack size of this LState
+		MinimizeStackMemory: true,             // Defaults to `false` if not specified. If set, the callstack will auto grow and shrink as needed up to a max of `CallStackSize`. If not set, the callstack will be fixed at `CallStackSize`.
+		SkipOpenLibs:        c.sandbox != nil, // the sandbox opens only the allowed libraries
+	})
+
+	if c.sandbox != nil {
+		c.sandbox.open(state)
+	}
+
+	inheritContext(state)
+	return state
+}
+
+// --------------------------------------------------------------------
+
+// WithConcurrency sets the maximum number of VMs in the pool, which is the number
+// of concurrent runs the script can serve. If zero or negative, the default
+// concurrency is used instead. Unless WithPoolSize is used, all of the VMs are
+// created upfront.
+func WithConcurrency(n int) Option {
+	return func(c *config) {
+		c.concurrency = n
+	}
+}
+
+// WithPoolSize sets the minimum and maximum number of VMs in the pool. The pool
+// starts with the minimum number of VMs (but at least one) and lazily grows up to
+// the maximum under load.
+func WithPoolSize(min, max int) Option {
+	return func(c *config) {
+		c.minimum = min
+		c.concurrency = max
+	}
+}
+
+// WithIdleTimeout sets the duration after which VMs which have not been used are
+// evicted from the pool, as long as the pool stays above its minimum size.
+func WithIdleTimeout(ttl time.Duration) Option {
+	return func(c *config) {
+		c.idleTimeout = ttl
+	}
+}
+
+// WithCallStackSize sets the maximum call stack size of each VM, which limits
+// the recursion depth of the script. The default is 64.
+func WithCallStackSize(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.callStack = n
+		}
+	}
+}
+
+// WithRegistryLimits sets the initial size of the registry of each VM, the maximum
+// size it can grow to and the step by which it grows when running out of space.
+func WithRegistryLimits(size, maxSize, growStep int) Option {
+	return func(c *config) {
+		c.registrySize = size
+		c.registryMax = maxSize
+		c.registryStep = growStep
+	}
+}
+
+// WithModules adds the modules which can be loaded by the script.
+func WithModules(modules ...Module) Option {
+	return func(c *config) {
+		c.modules = append(c.modules, modules...)
+	}
+}
+
+// WithSandbox restricts the standard libraries and functions available to the
+// script to the ones in the allowlist. Use DefaultSandbox for a safe profile.
+func WithSandbox(sandbox Sandbox) Option {
+	return func(c *config) {
+		c.sandbox = sandbox
+	}
+}
+
+// WithBudget sets the maximum number of VM instructions a single run is allowed to
+// execute, including the ones executed within coroutines. Runs exceeding it are
+// aborted with a *BudgetError.
+func WithBudget(instructions uint64) Option {
+	return func(c *config) {
+		c.budget = instructions
+	}
+}
+
+// WithMemoryLimit sets the approximate maximum number of bytes the values of a VM
+// are allowed to use. Runs exceeding it are aborted with a *MemoryError and the VM
+// is replaced with a fresh one. The memory is estimated by periodically walking the
+// values reachable by the script, including the ones held by coroutines, so the limit
+// is not exact.
+func WithMemoryLimit(bytes uint64) Option {
+	return func(c *config) {
+		c.memory = bytes
+	}
+}
+
+// WithQueueLimit sets the maximum number of runs which can wait for a VM to become
+// available. Once reached, runs fail immediately with ErrOverloaded instead of
+// waiting. If zero, the number of waiting runs is unlimited.
+func WithQueueLimit(n int) Option {
+	return func(c *config) {
+		c.queue = n
+	}
+}
+
+// WithIsolation restores the global environment of the VM after every run, so that
+// the globals written by a run, the modules it loaded or the changes it made to the
+// tables of the modules are not visible to the next run on the same VM. The global
+// environment is copied shallowly once the VM is initialized, so changes to nested
+// tables are not undone.
+func WithIsolation() Option {
+	return func(c *config) {
+		c.isolate = true
+	}
+}
+
+// WithHistory sets the number of versions of the script which are kept, including
+// the current one, so that the script can be rolled back to them. By default, the
+// last 8 versions are kept.
+func WithHistory(n int) Option {
+	return func(c *config) {
+		c.history = n
+	}
+}
+
+// WithConfig sets the configuration passed to the init(config) function of the
+// script. If the script defines an init function, it is called once for every VM
+// after the script is loaded, and the errors it raises fail the update.
+func WithConfig(value Value) Option {
+	return func(c *config) {
+		c.config = value
+	}
+}
+
+// WithGlobals sets the globals installed in every VM before the script runs, such
+// as feature flags or lookup tables. The values can be of any Value type, such as
+// a Table, or Go values which are converted the same way as run arguments. The
+// globals can be replaced later on with SetGlobals.
+func WithGlobals(globals map[string]any) Option {
+	return func(c *config) {
+		c.globals = copyGlobals(globals)
+	}
+}
+
+// WithContextValues allows the script to read request-scoped values of the context
+// of a run with context.value(name), where the keys are the names exposed to the
+// script and the values are the keys of the context values.
+func WithContextValues(keys map[string]any) Option {
+	return func(c *config) {
+		c.values = keys
+	}
+}
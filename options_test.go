@@ -0,0 +1,89 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const recursive = `
+function depth(n)
+	if n == 0 then return 0 end
+	return 1 + depth(n - 1)
+end
+
+function main(n)
+	return depth(n)
+end`
+
+func TestDefaultConfig(t *testing.T) {
+	c := newConfig(nil)
+	assert.Equal(t, defaultConcurrency, c.concurrency)
+	assert.Equal(t, defaultConcurrency, c.minimum)
+	assert.Equal(t, 64, c.callStack)
+	assert.Equal(t, 1024*4, c.registrySize)
+	assert.Equal(t, 1024*128, c.registryMax)
+	assert.Equal(t, 32, c.registryStep)
+	assert.Empty(t, c.modules)
+
+	c = newConfig([]Option{WithConcurrency(-1), WithCallStackSize(0)})
+	assert.Equal(t, defaultConcurrency, c.concurrency)
+	assert.Equal(t, 64, c.callStack)
+
+	c = newConfig([]Option{WithPoolSize(2, 8), WithIdleTimeout(time.Minute)})
+	assert.Equal(t, 8, c.concurrency)
+	assert.Equal(t, 2, c.minimum)
+	assert.Equal(t, time.Minute, c.idleTimeout)
+
+	c = newConfig([]Option{WithPoolSize(10, 8)})
+	assert.Equal(t, 8, c.minimum)
+}
+
+func TestWithCallStackSize(t *testing.T) {
+	{ // Default call stack is too small
+		s, err := New("test.lua", strings.NewReader(recursive))
+		assert.NoError(t, err)
+
+		_, err = s.Run(context.Background(), 100)
+		assert.Error(t, err)
+	}
+
+	{ // Larger call stack
+		s, err := New("test.lua", strings.NewReader(recursive),
+			WithCallStackSize(256),
+		)
+		assert.NoError(t, err)
+
+		out, err := s.Run(context.Background(), 100)
+		assert.NoError(t, err)
+		assert.Equal(t, Number(100), out)
+	}
+}
+
+func TestWithOptions(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	local api = require("test")
+
+	function main(input)
+		return api.echo(input)
+	end`),
+		WithConcurrency(2),
+		WithRegistryLimits(1024, 1024*64, 64),
+		WithModules(testModule()),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, s.Concurrency())
+	assert.Equal(t, 1024, s.conf.registrySize)
+	assert.Equal(t, 1024*64, s.conf.registryMax)
+	assert.Equal(t, 64, s.conf.registryStep)
+
+	out, err := s.Run(context.Background(), "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, String("hello"), out)
+}
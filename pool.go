@@ -0,0 +1,487 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"errors"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+var (
+	// ErrOverloaded is returned when too many runs are already waiting for a VM
+	ErrOverloaded = errors.New("lua: too many runs waiting for a VM")
+
+	// ErrClosed is returned when the script is used after being closed
+	ErrClosed = errors.New("lua: script is closed")
+
+	// ErrVMLimit is returned when a VM is needed, but the pool has none or a script
+	// is loaded, and the maximum number of VMs shared with other pools is reached
+	ErrVMLimit = errors.New("lua: too many VMs")
+)
+
+// defaultConcurrency sets the default concurrency for the VM pool
+var defaultConcurrency = int(math.Min(
+	float64(runtime.GOMAXPROCS(-1)), float64(runtime.NumCPU()),
+))
+
+// Stats represents the runtime statistics of a script
+type Stats struct {
+	Size       int           // The number of VMs currently in the pool
+	Idle       int           // The number of VMs currently not running
+	Waiting    int           // The number of runs currently waiting for a VM
+	Waits      uint64        // The number of runs which had to wait for a VM
+	WaitTime   time.Duration // The total time spent waiting for a VM
+	Overloaded uint64        // The number of runs rejected since the queue was full
+	Evicted    uint64        // The number of VMs evicted after being idle
+	Recycled   uint64        // The number of VMs replaced after a failed run
+}
+
+// counters represents the counters of a script, shared across its pools
+type counters struct {
+	waiting    atomic.Int64
+	waits      atomic.Uint64
+	waitTime   atomic.Int64
+	overloaded atomic.Uint64
+	evicted    atomic.Uint64
+	recycled   atomic.Uint64
+}
+
+// snapshot returns the current values of the counters
+func (c *counters) snapshot() Stats {
+	return Stats{
+		Waiting:    int(c.waiting.Load()),
+		Waits:      c.waits.Load(),
+		WaitTime:   time.Duration(c.waitTime.Load()),
+		Overloaded: c.overloaded.Load(),
+		Evicted:    c.evicted.Load(),
+		Recycled:   c.recycled.Load(),
+	}
+}
+
+// --------------------------------------------------------------------
+
+// Pool holds a pool of runtimes which starts with a minimum number of VMs and
+// lazily grows up to a maximum under load. VMs idle for longer than the idle
+// timeout are evicted, as long as the pool stays above its minimum size.
+type pool struct {
+	lock  sync.Mutex
+	idle  []*vm              // The idle VMs, most recently used last
+	size  int                // The number of VMs, both idle and running
+	slots chan struct{}      // The slots, one for every VM which can run
+	done  chan struct{}      // Closed once the pool is closed
+	shut  bool               // Whether the pool is closed
+	code  *lua.FunctionProto // The code of the script run by the VMs
+	env   map[string]any     // The globals installed in the VMs
+	owner *Script            // The script which owns the pool
+	min   int                // The minimum number of VMs
+	ttl   time.Duration      // The idle timeout of the VMs, or 0
+	queue int64              // The maximum number of waiting runs, or 0
+	stats *counters          // The counters of the script
+	limit *limiter           // The limit of VMs shared with other pools, or nil
+	park  int                // The number of slots given up due to the limit
+	lent  atomic.Int64       // The number of VMs accounted for by the next pool
+}
+
+// newPool creates a new pool of runtimes for the code. At least one VM is always
+// created so that the code is validated.
+func newPool(ctx context.Context, s *Script, code *lua.FunctionProto) (*pool, error) {
+	p := &pool{
+		slots: make(chan struct{}, s.conf.concurrency),
+		done:  make(chan struct{}),
+		code:  code,
+		env:   s.globals,
+		owner: s,
+		min:   s.conf.minimum,
+		ttl:   s.conf.idleTimeout,
+		queue: int64(s.conf.queue),
+		stats: &s.stats,
+		limit: s.conf.limit,
+	}
+
+	for i := 0; i < s.conf.concurrency; i++ {
+		p.slots <- struct{}{}
+	}
+
+	// The minimum VMs must fit within the limit. When replacing a pool, the VMs of
+	// the previous one are handed over, since they are disposed once drained.
+	prev := s.pool.Load()
+	lent := p.borrow(prev)
+	for i := 0; i < p.min || i == 0; i++ {
+		if i >= lent && !p.limit.acquire() {
+			p.abort(prev, lent)
+			return nil, ErrVMLimit
+		}
+
+		vm, err := newVM(s, code, p.env)
+		if err == nil {
+			err = ctx.Err()
+		}
+
+		if err != nil {
+			if vm != nil {
+				vm.exec.Close()
+			}
+			if i >= lent {
+				p.limit.release()
+			}
+			p.abort(prev, lent)
+			return nil, err
+		}
+
+		p.size++
+		p.idle = append(p.idle, vm)
+	}
+
+	if p.ttl > 0 {
+		go p.evictEvery(p.ttl / 2)
+	}
+	return p, nil
+}
+
+// Acquire gets a state from the pool, waiting until one is available or the
+// context is done. If too many runs are already waiting, it fails immediately.
+func (p *pool) Acquire(ctx context.Context) (*vm, error) {
+	if err := p.reserve(ctx); err != nil {
+		return nil, err
+	}
+
+	// Reuse the most recently used VM, since it is more likely to be warm
+	p.lock.Lock()
+	if p.shut {
+		p.lock.Unlock()
+		p.slots <- struct{}{}
+		return nil, ErrClosed
+	}
+
+	if n := len(p.idle); n > 0 {
+		vm := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.lock.Unlock()
+		return vm, nil
+	}
+
+	p.size++
+	p.lock.Unlock()
+
+	// There are no idle VMs, but we have a slot so we can grow the pool, unless
+	// too many VMs exist across the pools sharing the limit
+	if !p.limit.acquire() {
+		return p.wait(ctx)
+	}
+
+	vm, err := newVM(p.owner, p.code, p.env)
+	if err != nil {
+		p.limit.release()
+		p.shrink()
+		return nil, err
+	}
+	return vm, nil
+}
+
+// wait gives up the slot which was reserved to grow the pool, since the limit of
+// VMs is reached, and waits for one of the existing VMs to be released instead.
+func (p *pool) wait(ctx context.Context) (*vm, error) {
+	p.lock.Lock()
+	p.size--
+	switch {
+	case p.shut:
+		p.lock.Unlock()
+		p.slots <- struct{}{}
+		return nil, ErrClosed
+	case p.size == 0: // There is no VM to wait for
+		p.lock.Unlock()
+		p.slots <- struct{}{}
+		return nil, ErrVMLimit
+	}
+
+	p.park++
+	p.lock.Unlock()
+	return p.Acquire(ctx)
+}
+
+// unpark returns a slot given up due to the limit of VMs, once the limit allows
+// the pool to grow again.
+func (p *pool) unpark() {
+	if p.limit == nil {
+		return
+	}
+
+	p.lock.Lock()
+	ok := p.park > 0 && !p.shut && p.limit.available()
+	if ok {
+		p.park--
+	}
+	p.lock.Unlock()
+
+	if ok {
+		p.slots <- struct{}{}
+	}
+}
+
+// reserve reserves a slot for running a VM, waiting for one if necessary
+func (p *pool) reserve(ctx context.Context) error {
+	select {
+	case <-p.slots:
+		return nil
+	default:
+	}
+
+	// We need to wait, unless the queue is already full
+	waiting := p.stats.waiting.Add(1)
+	defer p.stats.waiting.Add(-1)
+	if p.queue > 0 && waiting > p.queue {
+		p.stats.overloaded.Add(1)
+		return ErrOverloaded
+	}
+
+	start := time.Now()
+	defer func() {
+		p.stats.waits.Add(1)
+		p.stats.waitTime.Add(int64(time.Since(start)))
+	}()
+
+	select {
+	case <-p.slots:
+		return nil
+	case <-p.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release returns a state to the pool, or closes it if the pool is closed.
+func (p *pool) Release(vm *vm) {
+	if !p.put(vm) {
+		p.dispose(vm)
+		p.shrink()
+		return
+	}
+
+	p.slots <- struct{}{}
+	p.unpark()
+}
+
+// Recycle closes a state which can no longer be used instead of returning it to
+// the pool, and asynchronously replaces it with a fresh one.
+func (p *pool) Recycle(vm *vm) {
+	p.dispose(vm)
+	p.stats.recycled.Add(1)
+	p.shrink()
+	go p.replace()
+}
+
+// replace creates a fresh VM and adds it to the idle list, unless the pool has
+// grown to its maximum size or has been closed in the meantime.
+func (p *pool) replace() {
+	if !p.limit.acquire() {
+		return
+	}
+
+	vm, err := newVM(p.owner, p.code, p.env)
+	if err != nil {
+		p.limit.release()
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.shut || p.size+p.park >= cap(p.slots) {
+		p.dispose(vm)
+		return
+	}
+
+	vm.used = time.Now()
+	p.size++
+	p.idle = append(p.idle, vm)
+}
+
+// put adds a state to the idle list, unless the pool is closed
+func (p *pool) put(vm *vm) bool {
+	if p.ttl > 0 {
+		vm.used = time.Now()
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.shut {
+		return false
+	}
+
+	p.idle = append(p.idle, vm)
+	return true
+}
+
+// dispose closes a VM which is no longer part of the pool
+func (p *pool) dispose(vm *vm) {
+	vm.exec.Close()
+
+	// The VM may still be accounted for by the pool which replaced this one
+	for n := p.lent.Load(); n > 0; n = p.lent.Load() {
+		if p.lent.CompareAndSwap(n, n-1) {
+			return
+		}
+	}
+	p.limit.release()
+}
+
+// borrow takes over the accounting of the VMs of the previous pool, up to the
+// minimum of this one, returning the number of VMs taken over.
+func (p *pool) borrow(prev *pool) int {
+	if prev == nil || p.limit == nil {
+		return 0
+	}
+
+	prev.lock.Lock()
+	defer prev.lock.Unlock()
+	if prev.shut {
+		return 0
+	}
+
+	n := prev.size - int(prev.lent.Load())
+	if p.min > 0 && n > p.min {
+		n = p.min
+	}
+	if p.min == 0 && n > 1 {
+		n = 1
+	}
+	if n <= 0 {
+		return 0
+	}
+
+	prev.lent.Add(int64(n))
+	return n
+}
+
+// giveBack returns the accounting of the VMs which were taken over but not created.
+// If the previous pool has disposed of them in the meantime, they are released.
+func (p *pool) giveBack(prev *pool, n int) {
+	for ; prev != nil && n > 0; n-- {
+		if prev.lent.Add(-1) < 0 {
+			prev.lent.Add(1)
+			p.limit.release()
+		}
+	}
+}
+
+// abort closes the VMs of a pool which failed to be created. The VMs which were
+// taken over from the previous pool are not released, since it still accounts for
+// them once its accounting is given back.
+func (p *pool) abort(prev *pool, lent int) {
+	for i, vm := range p.idle {
+		if vm.exec.Close(); i >= lent {
+			p.limit.release()
+		}
+	}
+
+	p.idle, p.size = nil, 0
+	p.giveBack(prev, lent)
+	p.Close(context.Background())
+}
+
+// shrink releases the slot of a VM which is no longer part of the pool
+func (p *pool) shrink() {
+	p.lock.Lock()
+	p.size--
+	p.lock.Unlock()
+	p.slots <- struct{}{}
+}
+
+// evictEvery periodically evicts the VMs which have been idle for too long
+func (p *pool) evictEvery(interval time.Duration) {
+	if interval < time.Millisecond { // The ticker panics unless it is positive
+		interval = time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			p.evict(now)
+		}
+	}
+}
+
+// evict closes the VMs which have been idle for longer than the idle timeout
+func (p *pool) evict(now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// The idle VMs are sorted by their last use, the oldest first
+	n := 0
+	for n < len(p.idle) && p.size > p.min && now.Sub(p.idle[n].used) >= p.ttl {
+		p.dispose(p.idle[n])
+		p.idle[n] = nil
+		p.size--
+		n++
+	}
+
+	if n > 0 {
+		p.idle = append(p.idle[:0], p.idle[n:]...)
+		p.stats.evicted.Add(uint64(n))
+	}
+}
+
+// snapshot adds the current size of the pool to the statistics
+func (p *pool) snapshot(stats *Stats) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	stats.Size = p.size
+	stats.Idle = len(p.idle)
+}
+
+// Close closes the pool so that no more VMs can be acquired, waits for all of the
+// running VMs to be released or the context to be done and closes the idle VMs.
+// The VMs which are still running once the context is done are closed as soon
+// as they are released.
+func (p *pool) Close(ctx context.Context) error {
+	p.lock.Lock()
+	if p.shut {
+		p.lock.Unlock()
+		return nil
+	}
+
+	p.shut = true
+	close(p.done)
+	parked := p.park
+	p.park = 0
+	p.lock.Unlock()
+
+	// Return the slots given up due to the limit of VMs
+	for i := 0; i < parked; i++ {
+		p.slots <- struct{}{}
+	}
+
+	// Wait for all of the running VMs to be released
+	defer p.closeIdle()
+	for i := 0; i < cap(p.slots); i++ {
+		select {
+		case <-p.slots:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// closeIdle closes all of the idle VMs of the pool
+func (p *pool) closeIdle() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, vm := range p.idle {
+		p.dispose(vm)
+		p.size--
+	}
+	p.idle = nil
+}
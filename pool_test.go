@@ -0,0 +1,309 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSleepScript(t *testing.T, options ...Option) *Script {
+	f, err := os.Open("fixtures/sleep.lua")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	s, err := New("test.lua", f, append(options, WithModules(testModule()))...)
+	assert.NoError(t, err)
+	return s
+}
+
+func TestAcquireTimeout(t *testing.T) {
+	s := newSleepScript(t, WithConcurrency(1))
+
+	// Occupy the only VM
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.Call(context.Background(), "main")
+	}()
+
+	time.Sleep(2 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	_, err := s.Run(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	wg.Wait()
+
+	stats := s.Stats()
+	assert.Equal(t, 0, stats.Waiting)
+	assert.Equal(t, uint64(1), stats.Waits)
+	assert.Greater(t, stats.WaitTime, time.Duration(0))
+}
+
+func TestAcquireOverloaded(t *testing.T) {
+	s := newSleepScript(t, WithConcurrency(1), WithQueueLimit(1))
+
+	// Occupy the only VM and the only slot in the queue
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			s.Run(context.Background())
+		}()
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	_, err := s.Run(context.Background())
+	assert.ErrorIs(t, err, ErrOverloaded)
+	wg.Wait()
+
+	stats := s.Stats()
+	assert.Equal(t, uint64(1), stats.Overloaded)
+	assert.Equal(t, uint64(1), stats.Waits)
+
+	// Once the queue is drained, runs must succeed again
+	_, err = s.Run(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestPoolGrow(t *testing.T) {
+	s := newSleepScript(t, WithPoolSize(0, 4))
+	assert.Equal(t, 4, s.Concurrency())
+	assert.Equal(t, 1, s.Stats().Size)
+
+	// Run more than the maximum concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Run(context.Background())
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+	stats := s.Stats()
+	assert.Equal(t, 4, stats.Size)
+	assert.Equal(t, 4, stats.Idle)
+}
+
+func TestPoolEvict(t *testing.T) {
+	s := newSleepScript(t, WithPoolSize(1, 4), WithIdleTimeout(20*time.Millisecond))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Run(context.Background())
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, 4, s.Stats().Size)
+
+	// Wait for the idle VMs to be evicted, down to the minimum
+	assert.Eventually(t, func() bool {
+		return s.Stats().Size == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, uint64(3), s.Stats().Evicted)
+
+	// The pool must still be usable
+	_, err := s.Run(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestPoolEvictTiny(t *testing.T) {
+	s := newSleepScript(t, WithPoolSize(0, 2), WithIdleTimeout(1))
+	_, err := s.Run(context.Background())
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return s.Stats().Size == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestPoolRecycle(t *testing.T) {
+	s := newSleepScript(t, WithPoolSize(1, 2))
+	p := s.pool.Load()
+
+	// Recycling replaces the VM asynchronously
+	vm, err := p.Acquire(context.Background())
+	assert.NoError(t, err)
+	p.Recycle(vm)
+	assert.Eventually(t, func() bool {
+		return s.Stats().Idle == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 1, s.Stats().Size)
+	assert.Equal(t, uint64(1), s.Stats().Recycled)
+
+	// The pool must not grow beyond its maximum
+	vm1, _ := p.Acquire(context.Background())
+	vm2, _ := p.Acquire(context.Background())
+	assert.Equal(t, 2, s.Stats().Size)
+	p.Recycle(vm1)
+	p.Release(vm2)
+	assert.Eventually(t, func() bool {
+		return s.Stats().Size == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 2, len(p.slots))
+	assert.Equal(t, uint64(2), s.Stats().Recycled)
+}
+
+func TestRecycleFailed(t *testing.T) {
+	const code = `
+	counter = 0
+	function main(fail)
+		counter = counter + 1
+		if fail then error("boom") end
+		return counter
+	end`
+
+	{ // Errors raised by the script unwind cleanly, so the VM is reused
+		s, err := New("test.lua", strings.NewReader(code), WithConcurrency(1))
+		assert.NoError(t, err)
+
+		out, err := s.Run(context.Background(), false)
+		assert.NoError(t, err)
+		assert.Equal(t, Number(1), out)
+
+		for i := 0; i < 50; i++ {
+			_, err = s.Run(context.Background(), true)
+			assert.Error(t, err)
+		}
+
+		out, err = s.Run(context.Background(), false)
+		assert.NoError(t, err)
+		assert.Equal(t, Number(52), out)
+		assert.Equal(t, uint64(0), s.Stats().Recycled)
+
+		// Missing functions do not run, so the VM is not recycled
+		_, err = s.Call(context.Background(), "missing")
+		assert.Error(t, err)
+		assert.Equal(t, uint64(0), s.Stats().Recycled)
+	}
+
+	{ // Aborted runs may leave the VM inconsistent, so it is replaced
+		s, err := New("test.lua", strings.NewReader(`
+		counter = 0
+		function main()
+			counter = counter + 1
+			while true do end
+		end
+		function count()
+			return counter
+		end`), WithConcurrency(1), WithBudget(1000))
+		assert.NoError(t, err)
+
+		_, err = s.Run(context.Background())
+		assert.ErrorIs(t, err, ErrBudgetExceeded)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = s.Run(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		assert.Eventually(t, func() bool {
+			return s.Stats().Recycled == 2 && s.Stats().Idle == 1
+		}, time.Second, time.Millisecond)
+
+		out, err := s.Call(context.Background(), "count")
+		assert.NoError(t, err)
+		assert.Equal(t, Number(0), out)
+	}
+
+	{ // With isolation, the globals are restored so the VM can be reused
+		s, err := New("test.lua", strings.NewReader(code), WithConcurrency(1), WithIsolation())
+		assert.NoError(t, err)
+
+		_, err = s.Run(context.Background(), true)
+		assert.Error(t, err)
+
+		out, err := s.Run(context.Background(), false)
+		assert.NoError(t, err)
+		assert.Equal(t, Number(1), out)
+		assert.Equal(t, uint64(0), s.Stats().Recycled)
+	}
+}
+
+func TestClose(t *testing.T) {
+	s := newSleepScript(t, WithConcurrency(2))
+	assert.Equal(t, 2, s.Stats().Size)
+
+	// Close must wait for the run in progress
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := s.Run(context.Background())
+		assert.NoError(t, err)
+	}()
+
+	time.Sleep(2 * time.Millisecond)
+	assert.NoError(t, s.Close())
+	assert.Equal(t, 0, s.Stats().Size)
+	wg.Wait()
+
+	// Once closed, the script can not be used anymore
+	_, err := s.Run(context.Background())
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorIs(t, s.Update(strings.NewReader(`function main() end`)), ErrClosed)
+	assert.NoError(t, s.Close())
+}
+
+func TestCloseContext(t *testing.T) {
+	s := newSleepScript(t, WithConcurrency(1))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := s.Run(context.Background())
+		assert.NoError(t, err)
+	}()
+
+	// Close must give up waiting once the context is done
+	time.Sleep(2 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.CloseContext(ctx), context.DeadlineExceeded)
+
+	// The run in progress is disposed of once complete
+	wg.Wait()
+	assert.Equal(t, 0, s.Stats().Size)
+	assert.NoError(t, s.CloseContext(ctx))
+}
+
+func TestCloseWaiting(t *testing.T) {
+	s := newSleepScript(t, WithConcurrency(1))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.Run(context.Background())
+	}()
+
+	// A run waiting for the VM must be woken up once closed
+	time.Sleep(2 * time.Millisecond)
+	go func() {
+		defer wg.Done()
+		_, err := s.Run(context.Background())
+		assert.ErrorIs(t, err, ErrClosed)
+	}()
+
+	time.Sleep(2 * time.Millisecond)
+	assert.NoError(t, s.Close())
+	wg.Wait()
+}
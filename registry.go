@@ -0,0 +1,210 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrScriptNotFound is returned when a script is not loaded in the registry
+	ErrScriptNotFound = errors.New("lua: script not found")
+
+	// ErrScriptExists is returned when a script with the same name is already loaded
+	ErrScriptExists = errors.New("lua: script already exists")
+)
+
+// ScriptInfo represents the information about a script loaded in a registry
+type ScriptInfo struct {
+	Name    string  // The name of the script
+	Version Version // The version currently running
+	Stats   Stats   // The runtime statistics of the script
+}
+
+// Registry owns a set of named scripts which share the same options, such as the
+// modules, and a limit on the number of VMs across all of their pools.
+type Registry struct {
+	lock    sync.RWMutex
+	done    bool               // Whether the registry is closed
+	scripts map[string]*Script // The scripts by their name
+	options []Option           // The options shared by the scripts
+	limit   *limiter           // The limit of VMs across the scripts, or nil
+}
+
+// NewRegistry creates a new registry allowing at most maxVMs VMs across all of
+// its scripts, or an unlimited number if zero or negative. The scripts start
+// with a single VM and grow under load, unless configured otherwise by the
+// options, which apply to every script of the registry. The minimum VMs of each
+// script count towards the limit, so loading a script fails with ErrVMLimit if
+// they do not fit.
+func NewRegistry(maxVMs int, options ...Option) *Registry {
+	r := &Registry{
+		scripts: make(map[string]*Script),
+		options: options,
+	}
+
+	if maxVMs > 0 {
+		r.limit = &limiter{max: int64(maxVMs)}
+	}
+	return r
+}
+
+// Load compiles and adds a new script to the registry, with additional options
+// applied on top of the shared ones.
+func (r *Registry) Load(name string, source io.Reader, options ...Option) error {
+	r.lock.RLock()
+	_, exists := r.scripts[name]
+	done := r.done
+	r.lock.RUnlock()
+	switch {
+	case done:
+		return ErrClosed
+	case exists:
+		return ErrScriptExists
+	}
+
+	opts := make([]Option, 0, len(r.options)+len(options)+2)
+	opts = append(opts, WithPoolSize(1, defaultConcurrency))
+	opts = append(opts, r.options...)
+	opts = append(opts, options...)
+	opts = append(opts, func(c *config) {
+		c.limit = r.limit
+	})
+
+	script, err := New(name, source, opts...)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	switch _, exists := r.scripts[name]; {
+	case r.done:
+		script.Close()
+		return ErrClosed
+	case exists:
+		script.Close()
+		return ErrScriptExists
+	}
+
+	r.scripts[name] = script
+	return nil
+}
+
+// Update updates the content of a script of the registry.
+func (r *Registry) Update(name string, source io.Reader) error {
+	script, err := r.Script(name)
+	if err != nil {
+		return err
+	}
+
+	return script.Update(source)
+}
+
+// Remove removes a script from the registry and closes it, waiting for its runs
+// in progress to complete.
+func (r *Registry) Remove(name string) error {
+	r.lock.Lock()
+	script, ok := r.scripts[name]
+	delete(r.scripts, name)
+	r.lock.Unlock()
+
+	if !ok {
+		return ErrScriptNotFound
+	}
+	return script.Close()
+}
+
+// Script returns a script of the registry by its name.
+func (r *Registry) Script(name string) (*Script, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if script, ok := r.scripts[name]; ok {
+		return script, nil
+	}
+	return nil, ErrScriptNotFound
+}
+
+// Run runs the main function of a script of the registry with arguments.
+func (r *Registry) Run(ctx context.Context, name string, args ...any) (Value, error) {
+	script, err := r.Script(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return script.Run(ctx, args...)
+}
+
+// List returns the information about the scripts of the registry, sorted by name.
+func (r *Registry) List() []ScriptInfo {
+	r.lock.RLock()
+	out := make([]ScriptInfo, 0, len(r.scripts))
+	for name, script := range r.scripts {
+		out = append(out, ScriptInfo{
+			Name:    name,
+			Version: script.Version(),
+			Stats:   script.Stats(),
+		})
+	}
+	r.lock.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// Close closes all of the scripts of the registry. Once closed, no more scripts
+// can be loaded.
+func (r *Registry) Close() error {
+	r.lock.Lock()
+	scripts := r.scripts
+	r.scripts = make(map[string]*Script)
+	r.done = true
+	r.lock.Unlock()
+
+	var errs []error
+	for _, script := range scripts {
+		errs = append(errs, script.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// --------------------------------------------------------------------
+
+// limiter limits the number of VMs across several pools
+type limiter struct {
+	max  int64        // The maximum number of VMs
+	used atomic.Int64 // The current number of VMs
+}
+
+// acquire accounts for a new VM, unless the limit is reached
+func (l *limiter) acquire() bool {
+	if l == nil {
+		return true
+	}
+
+	if l.used.Add(1) > l.max {
+		l.used.Add(-1)
+		return false
+	}
+	return true
+}
+
+// release accounts for a VM which was closed
+func (l *limiter) release() {
+	if l != nil {
+		l.used.Add(-1)
+	}
+}
+
+// available returns whether a new VM can be created
+func (l *limiter) available() bool {
+	return l == nil || l.used.Load() < l.max
+}
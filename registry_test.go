@@ -0,0 +1,159 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(0, WithModules(testModule()))
+	assert.NoError(t, r.Load("b.lua", strings.NewReader(`function main(n) return n * 2 end`)))
+	assert.NoError(t, r.Load("a.lua", strings.NewReader(`
+	local api = require("test")
+	function main(n) return api.sum(n, 1) end`)))
+	assert.ErrorIs(t, r.Load("a.lua", strings.NewReader(`function main() end`)), ErrScriptExists)
+
+	out, err := r.Run(context.Background(), "a.lua", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, Number(2), out)
+
+	out, err = r.Run(context.Background(), "b.lua", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, Number(4), out)
+
+	// Update one of the scripts
+	assert.NoError(t, r.Update("b.lua", strings.NewReader(`function main(n) return n * 3 end`)))
+	out, err = r.Run(context.Background(), "b.lua", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, Number(6), out)
+
+	// List the scripts, each starting with a single VM
+	list := r.List()
+	assert.Len(t, list, 2)
+	assert.Equal(t, "a.lua", list[0].Name)
+	assert.Equal(t, "b.lua", list[1].Name)
+	assert.Equal(t, 1, list[1].Stats.Size)
+	assert.Len(t, list[1].Version.Hash, 64)
+
+	// Remove one of the scripts
+	s, err := r.Script("b.lua")
+	assert.NoError(t, err)
+	assert.NoError(t, r.Remove("b.lua"))
+	assert.ErrorIs(t, r.Remove("b.lua"), ErrScriptNotFound)
+	assert.ErrorIs(t, r.Update("b.lua", strings.NewReader(``)), ErrScriptNotFound)
+	_, err = r.Run(context.Background(), "b.lua", 2)
+	assert.ErrorIs(t, err, ErrScriptNotFound)
+	_, err = s.Run(context.Background(), 2)
+	assert.ErrorIs(t, err, ErrClosed)
+
+	// Once closed, scripts can no longer be loaded
+	assert.NoError(t, r.Close())
+	assert.Len(t, r.List(), 0)
+	assert.ErrorIs(t, r.Load("c.lua", strings.NewReader(`function main() end`)), ErrClosed)
+}
+
+func TestRegistryLimit(t *testing.T) {
+	r := NewRegistry(3, WithModules(testModule()), WithPoolSize(1, 4))
+	defer r.Close()
+
+	for _, name := range []string{"a.lua", "b.lua"} {
+		f, err := os.Open("fixtures/sleep.lua")
+		assert.NoError(t, err)
+		assert.NoError(t, r.Load(name, f))
+		f.Close()
+	}
+
+	// Only a single additional VM can be created across the scripts
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Run(context.Background(), "a.lua")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	a, _ := r.Script("a.lua")
+	b, _ := r.Script("b.lua")
+	assert.LessOrEqual(t, a.Stats().Size, 2)
+	assert.Equal(t, 1, b.Stats().Size)
+	assert.Equal(t, int64(a.Stats().Size+1), r.limit.used.Load())
+
+	// Removing a script frees up its VMs
+	assert.NoError(t, r.Remove("a.lua"))
+	assert.Equal(t, int64(1), r.limit.used.Load())
+}
+
+func TestRegistryLimitMinimum(t *testing.T) {
+	r := NewRegistry(2, WithModules(testModule()))
+	defer r.Close()
+
+	for _, name := range []string{"a.lua", "b.lua"} {
+		assert.NoError(t, r.Load(name, strings.NewReader(`function main() return 1 end`)))
+	}
+
+	// The minimum VMs of another script do not fit within the limit
+	assert.ErrorIs(t, r.Load("c.lua", strings.NewReader(`function main() return 1 end`)), ErrVMLimit)
+	assert.Equal(t, int64(2), r.limit.used.Load())
+
+	// Updates hand over the VMs of the previous version
+	for i := 2; i < 5; i++ {
+		assert.NoError(t, r.Update("a.lua", strings.NewReader(fmt.Sprintf(`function main() return %d end`, i))))
+		out, err := r.Run(context.Background(), "a.lua")
+		assert.NoError(t, err)
+		assert.Equal(t, Number(i), out)
+	}
+
+	assert.Eventually(t, func() bool {
+		return r.limit.used.Load() == 2
+	}, time.Second, time.Millisecond)
+
+	// Removing a script makes room for another one
+	assert.NoError(t, r.Remove("b.lua"))
+	assert.NoError(t, r.Load("c.lua", strings.NewReader(`function main() return 1 end`)))
+	assert.Equal(t, int64(2), r.limit.used.Load())
+}
+
+func TestRegistryLimitFailedUpdate(t *testing.T) {
+	var calls atomic.Int32
+	counter := &NativeModule{Name: "counter"}
+	must(counter.Register("next", func() (Number, error) {
+		return Number(calls.Add(1)), nil
+	}))
+
+	r := NewRegistry(3, WithModules(counter), WithPoolSize(2, 2))
+	defer r.Close()
+	assert.NoError(t, r.Load("a.lua", strings.NewReader(`function main() return 1 end`)))
+	assert.Equal(t, int64(2), r.limit.used.Load())
+
+	// The second VM of the new version fails to initialize
+	assert.Error(t, r.Update("a.lua", strings.NewReader(`
+	if require("counter").next() == 2 then error("boom") end
+	function main() return 2 end`)))
+
+	out, err := r.Run(context.Background(), "a.lua")
+	assert.NoError(t, err)
+	assert.Equal(t, Number(1), out)
+
+	// The VMs of the previous version are still accounted for
+	assert.Equal(t, int64(2), r.limit.used.Load())
+	assert.ErrorIs(t, r.Load("b.lua", strings.NewReader(`function main() return 1 end`)), ErrVMLimit)
+	assert.Equal(t, int64(2), r.limit.used.Load())
+
+	// Once closed, all of the VMs are released
+	assert.NoError(t, r.Remove("a.lua"))
+	assert.Equal(t, int64(0), r.limit.used.Load())
+}
@@ -0,0 +1,101 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Sandbox represents an allowlist of the standard libraries and their functions
+// which are available to the script. Each key is the name of a library, such as
+// "os" or "string" with an empty name for the base functions, and the value is
+// the list of functions to keep. If the list is empty, the entire library is kept.
+// The "package" library is always loaded, as the modules are loaded through it,
+// but scripts can only require modules and not files when sandboxed.
+type Sandbox map[string][]string
+
+// DefaultSandbox is a safe profile which does not allow scripts to access the
+// file system, the environment, the process or the debug facilities. Coroutines
+// are allowed, as they are accounted for by the instruction budget and memory limit.
+var DefaultSandbox = Sandbox{
+	lua.BaseLibName: {
+		"assert", "error", "getmetatable", "ipairs", "next", "pairs", "pcall", "print",
+		"rawequal", "rawget", "rawset", "require", "select", "setmetatable", "tonumber",
+		"tostring", "type", "unpack", "xpcall",
+	},
+	lua.OsLibName:        {"clock", "date", "difftime", "time"},
+	lua.StringLibName:    nil,
+	lua.TabLibName:       nil,
+	lua.MathLibName:      nil,
+	lua.CoroutineLibName: nil,
+}
+
+// stdlibs is the list of standard libraries, package and base must come first
+var stdlibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.LoadLibName, lua.OpenPackage},
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.IoLibName, lua.OpenIo},
+	{lua.OsLibName, lua.OpenOs},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+	{lua.DebugLibName, lua.OpenDebug},
+	{lua.ChannelLibName, lua.OpenChannel},
+	{lua.CoroutineLibName, lua.OpenCoroutine},
+}
+
+// open opens the allowed libraries in the state and removes the functions which
+// are not in the allowlist.
+func (sb Sandbox) open(state *lua.LState) {
+	for _, lib := range stdlibs {
+		allowed, ok := sb[lib.name]
+		if !ok && lib.name != lua.LoadLibName {
+			continue
+		}
+
+		state.Push(state.NewFunction(lib.open))
+		state.Push(lua.LString(lib.name))
+		state.Call(1, 0)
+
+		// Base functions are registered as globals, which is safe to filter since
+		// the rest of the libraries are tables and not yet loaded.
+		table := state.G.Global
+		if lib.name != lua.BaseLibName {
+			table, _ = state.GetGlobal(lib.name).(*lua.LTable)
+		}
+
+		if table != nil && len(allowed) > 0 {
+			restrict(table, allowed)
+		}
+	}
+
+	// Only keep the preload loader, so that files can not be loaded
+	if loaders, ok := state.GetField(state.GetGlobal(lua.LoadLibName), "loaders").(*lua.LTable); ok {
+		for i := loaders.Len(); i > 1; i-- {
+			loaders.RawSetInt(i, lua.LNil)
+		}
+	}
+}
+
+// restrict removes all of the functions of the table which are not allowed
+func restrict(table *lua.LTable, allowed []string) {
+	keep := make(map[string]bool, len(allowed))
+	for _, name := range allowed {
+		keep[name] = true
+	}
+
+	var remove []lua.LValue
+	table.ForEach(func(k, v lua.LValue) {
+		if v.Type() == lua.LTFunction && !keep[k.String()] {
+			remove = append(remove, k)
+		}
+	})
+
+	for _, k := range remove {
+		table.RawSet(k, lua.LNil)
+	}
+}
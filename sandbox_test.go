@@ -0,0 +1,136 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandboxUnreachable(t *testing.T) {
+	for _, expr := range []string{
+		`dofile("fixtures/fib.lua")`,
+		`loadfile("fixtures/fib.lua")`,
+		`load("return 1")`,
+		`loadstring("return 1")`,
+		`getfenv(1)`,
+		`setfenv(1, {})`,
+		`module("x")`,
+		`collectgarbage()`,
+		`os.execute("echo")`,
+		`os.exit(1)`,
+		`os.getenv("HOME")`,
+		`os.setenv("HOME", "")`,
+		`os.remove("fixtures/fib.lua")`,
+		`os.rename("fixtures/fib.lua", "fixtures/fib2.lua")`,
+		`os.tmpname()`,
+		`io.open("fixtures/fib.lua")`,
+		`io.write("x")`,
+		`debug.traceback()`,
+		`debug.getinfo(1)`,
+		`channel.make()`,
+		`require("fixtures.fib")`,
+		`package.loadlib("x", "y")`,
+	} {
+		s, err := New("test.lua", strings.NewReader(`
+		function main()
+			return `+expr+`
+		end`), WithSandbox(DefaultSandbox))
+		assert.NoError(t, err, expr)
+
+		_, err = s.Run(context.Background())
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestSandboxReachable(t *testing.T) {
+	for _, expr := range []string{
+		`os.time()`,
+		`os.clock()`,
+		`os.date("%Y")`,
+		`string.upper("x")`,
+		`("x"):upper()`,
+		`table.concat({"a", "b"})`,
+		`math.floor(1.5)`,
+		`coroutine.running()`,
+		`pcall(error, "x")`,
+		`tostring(tonumber("1"))`,
+		`require("json").encode({1, 2})`,
+		`require("test").echo("x")`,
+	} {
+		s, err := New("test.lua", strings.NewReader(`
+		function main()
+			return `+expr+`
+		end`), WithSandbox(DefaultSandbox), WithModules(testModule()))
+		assert.NoError(t, err, expr)
+
+		_, err = s.Run(context.Background())
+		assert.NoError(t, err, expr)
+	}
+}
+
+func TestSandboxLimits(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	function main(name)
+		if name == "budget" then
+			return coroutine.wrap(function()
+				local sum = 0
+				for i = 1, 5e6 do
+					sum = sum + i
+				end
+				return sum
+			end)()
+		else
+			return coroutine.wrap(function()
+				local t = {}
+				for i = 1, 200000 do
+					t[i] = i
+				end
+				return #t
+			end)()
+		end
+	end`), WithSandbox(DefaultSandbox), WithBudget(1e6), WithMemoryLimit(100000))
+	assert.NoError(t, err)
+
+	_, err = s.Run(context.Background(), "budget")
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+
+	_, err = s.Run(context.Background(), "memory")
+	assert.ErrorIs(t, err, ErrMemoryLimit)
+}
+
+func TestSandboxCustom(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	function main()
+		return type(os.time), type(os.exit), type(string), type(io)
+	end`), WithSandbox(Sandbox{
+		"":   {"type"},
+		"os": {"time"},
+	}))
+	assert.NoError(t, err)
+
+	out, err := s.RunMulti(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{
+		String("function"), String("nil"), String("nil"), String("nil"),
+	}, out)
+}
+
+func TestNoSandbox(t *testing.T) {
+	s, err := FromString("test.lua", `
+	function main()
+		local fib = require("fixtures.fib")
+		return type(os.exit), type(io.open), type(dofile)
+	end`)
+	assert.NoError(t, err)
+
+	out, err := s.RunMulti(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{
+		String("function"), String("function"), String("function"),
+	}, out)
+}
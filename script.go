@@ -4,15 +4,14 @@
 package lua
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"math"
-	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/kelindar/lua/json"
 	lua "github.com/yuin/gopher-lua"
@@ -23,38 +22,60 @@ var (
 	errInvalidScript = errors.New("lua: script is not in a valid state")
 )
 
+// FunctionNotFoundError is returned when the function requested to be called
+// is not defined as a global function by the script.
+type FunctionNotFoundError struct {
+	Name string // The name of the function
+}
+
+// Error returns the error message
+func (e *FunctionNotFoundError) Error() string {
+	return fmt.Sprintf("lua: %s() function not found", e.Name)
+}
+
 // Script represents a LUA script
 type Script struct {
-	lock sync.RWMutex
-	name string             // The name of the script
-	conc int                // The concurrency setting for the VM pool
-	pool pool               // The pool of runtimes for concurrent use
-	mods []Module           // The injected modules
-	code *lua.FunctionProto // The precompiled code
+	lock    sync.Mutex              // Serializes updates of the script
+	done    atomic.Bool             // Whether the script is closed
+	name    string                  // The name of the script
+	conf    config                  // The configuration of the script
+	pool    atomic.Pointer[pool]    // The pool of runtimes for concurrent use
+	version atomic.Pointer[Version] // The version currently running
+	history []*Version              // The versions kept for rollback, oldest first
+	users   []*Script               // The scripts using this one as a module
+	globals map[string]any          // The globals installed before the script runs
+	stats   counters                // The runtime counters of the script
 }
 
-// New creates a new script from an io.Reader
-func New(name string, source io.Reader, concurrency int, modules ...Module) (*Script, error) {
-	if concurrency <= 0 {
-		concurrency = defaultConcurrency
-	}
-
+// New creates a new script from an io.Reader, configured with the options provided.
+func New(name string, source io.Reader, options ...Option) (*Script, error) {
 	script := &Script{
 		name: name,
-		mods: modules,
-		conc: concurrency,
+		conf: newConfig(options),
+	}
+	script.globals = script.conf.globals
+
+	if err := script.Update(source); err != nil {
+		return script, err
+	}
+
+	// Reload the script whenever one of its script modules is updated
+	for _, m := range script.conf.modules {
+		if m, ok := m.(*ScriptModule); ok {
+			m.Script.attach(script)
+		}
 	}
-	return script, script.Update(source)
+	return script, nil
 }
 
 // FromReader reads a script fron an io.Reader
 func FromReader(name string, r io.Reader, modules ...Module) (*Script, error) {
-	return New(name, r, 0, modules...)
+	return New(name, r, WithModules(modules...))
 }
 
 // FromString reads a script fron a string
 func FromString(name, code string, modules ...Module) (*Script, error) {
-	return New(name, bytes.NewBufferString(code), 0, modules...)
+	return New(name, bytes.NewBufferString(code), WithModules(modules...))
 }
 
 // Name returns the name of the script
@@ -64,46 +85,232 @@ func (s *Script) Name() string {
 
 // Concurrency returns the concurrency setting of the script
 func (s *Script) Concurrency() int {
-	return s.conc
+	return s.conf.concurrency
+}
+
+// Stats returns the runtime statistics of the script
+func (s *Script) Stats() Stats {
+	stats := s.stats.snapshot()
+	if pool := s.pool.Load(); pool != nil {
+		pool.snapshot(&stats)
+	}
+	return stats
 }
 
 // Run runs the main function of the script with arguments.
 func (s *Script) Run(ctx context.Context, args ...any) (Value, error) {
+	return s.Call(ctx, "main", args...)
+}
+
+// RunMulti runs the main function of the script with arguments and returns
+// all of the values returned by the function.
+func (s *Script) RunMulti(ctx context.Context, args ...any) ([]Value, error) {
+	return s.CallMulti(ctx, "main", args...)
+}
+
+// Call runs a global function of the script with arguments. If the function is
+// not defined by the script, a *FunctionNotFoundError is returned.
+func (s *Script) Call(ctx context.Context, name string, args ...any) (Value, error) {
+	pool, vm, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := vm.Call(ctx, name, args)
+	release(pool, vm, err)
+	return out, err
+}
+
+// CallMulti runs a global function of the script with arguments and returns
+// all of the values returned by the function.
+func (s *Script) CallMulti(ctx context.Context, name string, args ...any) ([]Value, error) {
+	pool, vm, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := vm.CallMulti(ctx, name, args)
+	release(pool, vm, err)
+	return out, err
+}
+
+// acquire acquires a VM from the current pool of the script. The VM must be
+// released back to the pool it was acquired from.
+func (s *Script) acquire(ctx context.Context) (*pool, *vm, error) {
+	for {
+		pool := s.pool.Load()
+		switch {
+		case s.done.Load():
+			return nil, nil, ErrClosed
+		case pool == nil: // The script has never compiled successfully
+			return nil, nil, errInvalidScript
+		}
 
-	// Protect swapping of the pools when the script is updated.
-	s.lock.RLock()
-	defer s.lock.RUnlock()
+		// If the pool was closed because it was replaced by an update, try again
+		// with the new pool.
+		vm, err := pool.Acquire(ctx)
+		if err == ErrClosed && s.pool.Load() != pool {
+			continue
+		}
 
-	// Acquire and release the pool of VMs, given our read lock we can still
-	// enter here concurrently so the pool must also be thread-safe.
-	vm := s.pool.Acquire()
-	defer s.pool.Release(vm)
+		return pool, vm, err
+	}
+}
 
-	// Run the script
-	return vm.Run(ctx, args)
+// release returns the VM to the pool once the run has completed. If the VM may
+// have been left in an inconsistent state, it is replaced with a fresh one.
+func release(pool *pool, vm *vm, err error) {
+	switch {
+	case vm.poisoned(err):
+		pool.Recycle(vm)
+	default:
+		pool.Release(vm)
+	}
 }
 
 // Update updates the content of the script.
-func (s *Script) Update(r io.Reader) (err error) {
-	code, err := s.compile(r)
+func (s *Script) Update(r io.Reader) error {
+	return s.UpdateContext(context.Background(), r)
+}
+
+// UpdateContext updates the content of the script. The new VMs are created while
+// the current ones keep serving runs, and once they are ready they atomically
+// replace the current ones. If the context is done before that, the update is
+// aborted. The replaced VMs are disposed of in the background once their runs
+// in progress are complete. If the source is identical to the running version,
+// the update does nothing. The scripts using this one as a module are reloaded.
+func (s *Script) UpdateContext(ctx context.Context, r io.Reader) error {
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	// Skip the rebuild if the source has not changed
+	version := newVersion(source, nil)
+	if current := s.version.Load(); current != nil && current.Hash == version.Hash && !s.done.Load() {
+		return nil
+	}
+
+	if version.code, err = s.compile(source); err != nil {
+		return syntaxError(s.name, err)
+	}
+
+	// Updates are serialized, but do not block the runs
+	s.lock.Lock()
+	err = s.apply(ctx, version)
+	if err == nil {
+		s.remember(version)
+	}
+	s.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return s.reload(ctx)
+}
+
+// apply replaces the running version of the script with a new pool of VMs for
+// the version provided. This must be called while holding the lock.
+func (s *Script) apply(ctx context.Context, version *Version) error {
+	if s.done.Load() {
+		return ErrClosed
+	}
+
+	// Create a new pool of VMs, keeping the previous one if this fails
+	pool, err := newPool(ctx, s, version.code)
+	if err != nil {
+		return err
+	}
+
+	s.version.Store(version)
+	if prev := s.pool.Swap(pool); prev != nil {
+		go prev.Close(context.Background())
+	}
+	return nil
+}
+
+// SetGlobals replaces the globals installed before the script runs, without
+// recompiling it. The new VMs are created while the current ones keep serving
+// runs, and if this fails, the current globals are kept.
+func (s *Script) SetGlobals(globals map[string]any) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// The script may have failed to compile when it was created
+	version := s.version.Load()
+	if version == nil {
+		return errInvalidScript
+	}
+
+	prev := s.globals
+	s.globals = copyGlobals(globals)
+	if err := s.apply(context.Background(), version); err != nil {
+		s.globals = prev
+		return err
+	}
+	return nil
+}
+
+// copyGlobals copies the globals, so they can no longer be modified by the caller
+func copyGlobals(globals map[string]any) map[string]any {
+	out := make(map[string]any, len(globals))
+	for k, v := range globals {
+		out[k] = v
+	}
+	return out
+}
+
+// reload rebuilds the pools of the scripts using this one as a module, so that
+// they pick up its running version.
+func (s *Script) reload(ctx context.Context) error {
+	s.lock.Lock()
+	users := append([]*Script(nil), s.users...)
+	s.lock.Unlock()
+
+	var errs []error
+	for _, user := range users {
+		if err := user.rebuild(ctx); err != nil && err != ErrClosed {
+			errs = append(errs, fmt.Errorf("lua: unable to reload %s: %w", user.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rebuild replaces the pool of the script with a new one for the same version,
+// and reloads the scripts using it as a module.
+func (s *Script) rebuild(ctx context.Context) error {
+	s.lock.Lock()
+	err := s.apply(ctx, s.version.Load())
+	s.lock.Unlock()
+
 	if err != nil {
 		return err
 	}
+	return s.reload(ctx)
+}
 
-	// Protect from now on, as we need to update the script while loading
+// attach registers a script which uses this one as a module
+func (s *Script) attach(user *Script) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	s.users = append(s.users, user)
+}
 
-	// Create a new pool of VMs
-	s.code = code
-	s.pool, err = newPool(s, s.conc)
-	return
+// detach unregisters a script which uses this one as a module
+func (s *Script) detach(user *Script) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, v := range s.users {
+		if v == user {
+			s.users = append(s.users[:i], s.users[i+1:]...)
+			return
+		}
+	}
 }
 
 // Compile compiles a script into a function that can be shared.
-func (s *Script) compile(r io.Reader) (*lua.FunctionProto, error) {
-	reader := bufio.NewReader(r)
-	chunk, err := parse.Parse(reader, s.name)
+func (s *Script) compile(source []byte) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(bytes.NewReader(source), s.name)
 	if err != nil {
 		return nil, err
 	}
@@ -112,28 +319,64 @@ func (s *Script) compile(r io.Reader) (*lua.FunctionProto, error) {
 	return lua.Compile(chunk, s.name)
 }
 
-// LoadModules loads in the prerequisite modules
+// LoadModules loads in the prerequisite modules, along with the context module
 func (s *Script) loadModules(runtime *lua.LState) error {
+	runtime.PreloadModule("context", contextLoader(s.conf.values))
+	return s.injectModules(runtime)
+}
+
+// InjectModules loads in the prerequisite modules. The context module is only
+// loaded by the script which is run, so the context values it allows take
+// precedence over the ones of its script modules.
+func (s *Script) injectModules(runtime *lua.LState) error {
 	runtime.PreloadModule("json", json.Loader)
-	for _, m := range s.mods {
+	for _, m := range s.conf.modules {
 		if err := m.inject(runtime); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
-// Close closes the script and cleanly disposes of its resources.
+// Close closes the script and cleanly disposes of its resources, waiting for
+// the runs in progress to complete. Once closed, the script can no longer be
+// run or updated and ErrClosed is returned instead.
 func (s *Script) Close() error {
-	return nil
+	return s.CloseContext(context.Background())
+}
+
+// CloseContext closes the script and cleanly disposes of its resources, waiting
+// for the runs in progress to complete or the context to be done. The runs which
+// are still in progress once the context is done are disposed of once complete.
+func (s *Script) CloseContext(ctx context.Context) error {
+	if !s.done.CompareAndSwap(false, true) {
+		return nil // Already closed
+	}
+
+	// Updates are not possible once closed, so we only need to close the latest
+	// pool once the update in progress, if any, is complete.
+	s.lock.Lock()
+	pool := s.pool.Load()
+	s.lock.Unlock()
+
+	// Stop being reloaded by the script modules
+	for _, m := range s.conf.modules {
+		if m, ok := m.(*ScriptModule); ok {
+			m.Script.detach(s)
+		}
+	}
+
+	if pool == nil {
+		return nil
+	}
+	return pool.Close(ctx)
 }
 
 // findFunction extracts a global function
 func findFunction(runtime *lua.LState, name string) (*lua.LFunction, error) {
 	fn := runtime.GetGlobal(name)
 	if fn == nil || fn.Type() != lua.LTFunction {
-		return nil, fmt.Errorf("lua: %s() function not found", name)
+		return nil, &FunctionNotFoundError{Name: name}
 	}
 
 	return fn.(*lua.LFunction), nil
@@ -143,112 +386,219 @@ func findFunction(runtime *lua.LState, name string) (*lua.LFunction, error) {
 
 // VM represents a single VM which can only be ran serially.
 type vm struct {
-	argn int            // The number of arguments
-	exec *lua.LState    // The pool of runtimes for concurrent use
-	main *lua.LFunction // The main function
+	exec    *lua.LState               // The pool of runtimes for concurrent use
+	script  string                    // The name of the script
+	funcs   map[string]*lua.LFunction // The resolved global functions
+	spent   budget                    // The budget of the current run
+	reset   *snapshot                 // The global environment to restore, or nil
+	fault   bool                      // Whether the last run has failed
+	used    time.Time                 // The last time the VM was released
+	trap    *lua.LFunction            // The error handler of the calls
+	entry   string                    // The function called by the host
+	trace   trace                     // The position of the last error
+	broken  bool                      // Whether a native function has panicked
+	natives map[string]error          // The native errors raised during the call
 }
 
-// newVM creates a new VM for a script
-func newVM(s *Script) (*vm, error) {
-	l := newState()
+// newVM creates a new VM for a script, running its compiled code
+func newVM(s *Script, code *lua.FunctionProto, globals map[string]any) (*vm, error) {
+	l := s.conf.newState()
 	v := &vm{
-		exec: l,
-		argn: 0,
-		main: nil,
+		exec:   l,
+		script: s.name,
+		funcs:  make(map[string]*lua.LFunction, 4),
+		spent: budget{
+			state:  l,
+			limit:  s.conf.budget,
+			memory: s.conf.memory,
+		},
 	}
+	v.trap = l.NewFunction(v.onError)
+
+	// Keep track of the VM, so the native functions can report their panics
+	owner := l.NewUserData()
+	owner.Value = v
+	l.SetField(l.Get(lua.RegistryIndex), vmKey, owner)
 
 	// Push the function to the runtime
-	codeFn := v.exec.NewFunctionFromProto(s.code)
+	codeFn := v.exec.NewFunctionFromProto(code)
 	v.exec.Push(codeFn)
 
 	// Inject the modules
 	if err := s.loadModules(v.exec); err != nil {
+		l.Close()
 		return nil, err
 	}
 
-	// Initialize by calling the script
-	if err := v.exec.PCall(0, lua.MultRet, nil); err != nil {
-		return nil, err
+	// Install the globals provided by the host
+	for name, value := range globals {
+		v.exec.SetGlobal(name, lvalueOf(v.exec, value))
+	}
+
+	// Initialize by calling the script, discarding anything it returns
+	if err := v.pcall(0, lua.MultRet, ""); err != nil {
+		l.Close()
+		return nil, v.failure(context.Background(), err)
+	}
+
+	v.exec.SetTop(0)
+
+	// Initialize the VM with the configuration, if the script has an init function
+	if err := v.init(s.conf.config); err != nil {
+		l.Close()
+		return nil, v.failure(context.Background(), err)
 	}
 
-	// If we have a main function, set it
-	if mainFn, err := findFunction(v.exec, "main"); err == nil {
-		v.argn = int(mainFn.Proto.NumParameters)
-		v.main = mainFn
+	// If we have a main function, resolve it upfront
+	v.function("main")
+
+	// Snapshot the global environment, so it can be restored after every run
+	if s.conf.isolate {
+		v.reset = newSnapshot(v.exec)
 	}
 	return v, nil
 }
 
-// Run runs the main function of the script with arguments.
-func (v *vm) Run(ctx context.Context, args []any) (Value, error) {
-	if v.main == nil {
-		return nil, errInvalidScript
+// init calls the init function of the script with the configuration, if defined
+func (v *vm) init(config Value) error {
+	fn, ok := v.exec.GetGlobal("init").(*lua.LFunction)
+	if !ok {
+		return nil
 	}
 
-	// Push the arguments into the state
-	exec := v.exec
-	exec.SetContext(ctx)
-	exec.Push(v.main)
-	for _, arg := range args {
-		exec.Push(lvalueOf(exec, arg))
+	v.exec.Push(fn)
+	v.exec.Push(lvalueOf(v.exec, config))
+	return v.pcall(1, 0, "init")
+}
+
+// pcall calls the function on the stack in protected mode, capturing the position
+// of the error if it fails. The entry is the name of the function being called.
+func (v *vm) pcall(nargs, nret int, entry string) error {
+	v.entry = entry
+	v.trace = trace{}
+	if len(v.natives) > 0 {
+		v.natives = nil
 	}
+	return v.exec.PCall(nargs, nret, v.trap)
+}
 
-	// Call the main function
-	if err := exec.PCall(len(args), 1, nil); err != nil {
-		return nil, err
+// vmKey is the registry key of the VM which owns a state
+const vmKey = "lua.vm"
+
+// vmOf returns the VM which owns a state, if any
+func vmOf(state *lua.LState) *vm {
+	if ud, ok := state.GetField(state.Get(lua.RegistryIndex), vmKey).(*lua.LUserData); ok {
+		v, _ := ud.Value.(*vm)
+		return v
 	}
+	return nil
+}
 
-	// Pop the returned value
-	result := exec.Get(-1)
-	exec.Pop(1)
-	return resultOf(result), nil
+// poisoned returns whether the VM may have been left in an inconsistent state by
+// a failed run. The errors raised by the script itself unwind the stack cleanly, so
+// only the runs which were aborted, ran out of their limits, left a dirty stack or
+// during which a native function has panicked require a fresh VM.
+func (v *vm) poisoned(err error) bool {
+	switch {
+	case v.broken: // Even if the script has caught the panic
+		return true
+	case err == nil || !v.fault:
+		return false
+	case v.exec.GetTop() != 0:
+		return true
+	default:
+		scriptErr, ok := err.(*ScriptError)
+		return !ok || scriptErr.Kind != KindRuntime
+	}
 }
 
-// newState creates a new LUA state
-func newState() *lua.LState {
-	return lua.NewState(lua.Options{
-		RegistrySize:        1024 * 4,   // this is the initial size of the registry
-		RegistryMaxSize:     1024 * 128, // this is the maximum size that the registry can grow to. If set to `0` (the default) then the registry will not auto grow
-		RegistryGrowStep:    32,         // this is how much to step up the registry by each time it runs out of space. The default is `32`.
-		CallStackSize:       64,         // this is the maximum callstack size of this LState
-		MinimizeStackMemory: true,       // Defaults to `false` if not specified. If set, the callstack will auto grow and shrink as needed up to a max of `CallStackSize`. If not set, the callstack will be fixed at `CallStackSize`.
-	})
+// restore restores the global environment of the VM, if isolation is enabled
+func (v *vm) restore() {
+	if v.reset != nil {
+		v.reset.restore()
+	}
 }
 
-// --------------------------------------------------------------------
+// function resolves a global function by its name, caching it on the VM so
+// that subsequent calls do not need to look it up again.
+func (v *vm) function(name string) (*lua.LFunction, error) {
+	if fn, ok := v.funcs[name]; ok {
+		return fn, nil
+	}
 
-// defaultConcurrency sets the default concurrency for the VM pool
-var defaultConcurrency = int(math.Min(
-	float64(runtime.GOMAXPROCS(-1)), float64(runtime.NumCPU()),
-))
-
-// Pool holds a pool of runtimes.
-type pool chan *vm
-
-// newPool creates a new pool of runtimes.
-func newPool(s *Script, concurrency int) (pool, error) {
-	pool := make(pool, concurrency)
-	for i := 0; i < concurrency; i++ {
-		vm, err := newVM(s)
-		if err != nil {
-			return nil, err
-		}
+	fn, err := findFunction(v.exec, name)
+	if err != nil {
+		return nil, err
+	}
 
-		pool <- vm
+	v.funcs[name] = fn
+	return fn, nil
+}
+
+// Call runs a global function of the script with arguments.
+func (v *vm) Call(ctx context.Context, name string, args []any) (Value, error) {
+	defer v.restore()
+	if _, err := v.call(ctx, name, args, 1); err != nil {
+		return nil, err
 	}
 
-	return pool, nil
+	// Pop the returned value
+	result := v.exec.Get(-1)
+	v.exec.Pop(1)
+	return resultOf(result), nil
 }
 
-// Acquire gets a state from the pool.
-func (p pool) Acquire() (vm *vm) {
-	return <-p // Wait until we have a VM
+// CallMulti runs a global function of the script with arguments and returns
+// all of the values returned by the function.
+func (v *vm) CallMulti(ctx context.Context, name string, args []any) ([]Value, error) {
+	defer v.restore()
+	n, err := v.call(ctx, name, args, lua.MultRet)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pop all of the returned values
+	exec := v.exec
+	out := make([]Value, n)
+	for i := 0; i < n; i++ {
+		out[i] = resultOf(exec.Get(i - n))
+	}
+
+	exec.Pop(n)
+	return out, nil
 }
 
-// Release returns a state to the pool.
-func (p pool) Release(vm *vm) {
-	select {
-	case p <- vm:
-	default: // Discard
+// call calls a global function with arguments, leaving the returned values on
+// the stack and returns the number of values returned.
+func (v *vm) call(ctx context.Context, name string, args []any, nret int) (int, error) {
+	fn, err := v.function(name)
+	if err != nil {
+		return 0, err
 	}
+
+	// Push the arguments into the state
+	exec := v.exec
+	top := exec.GetTop()
+	exec.Push(fn)
+	for _, arg := range args {
+		exec.Push(lvalueOf(exec, arg))
+	}
+
+	// Count the instructions executed and memory used, if we have limits
+	switch {
+	case v.spent.limit == 0 && v.spent.memory == 0:
+		exec.SetContext(ctx)
+	default:
+		v.spent.reset(ctx)
+		exec.SetContext(&v.spent)
+	}
+
+	// Call the function
+	v.fault = false
+	if err := v.pcall(len(args), nret, name); err != nil {
+		v.fault = true
+		return 0, v.failure(ctx, err)
+	}
+
+	return exec.GetTop() - top, nil
 }
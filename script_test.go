@@ -6,8 +6,12 @@ package lua
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -23,12 +27,13 @@ type Person struct {
 }
 
 /*
-Benchmark_Serial/fib-10         	 7079620	       169.1 ns/op	      16 B/op	       2 allocs/op
-Benchmark_Serial/empty-10       	 9799070	       122.8 ns/op	       0 B/op	       0 allocs/op
-Benchmark_Serial/update-10      	 1353627	       880.6 ns/op	     224 B/op	      14 allocs/op
-Benchmark_Serial/table-10       	 3123861	       386.4 ns/op	      57 B/op	       3 allocs/op
-Benchmark_Serial/sleep-sigle-10 	     100	  11010793 ns/op	       0 B/op	       0 allocs/op
-Benchmark_Serial/sleep-multi-10 	    1093	   1108391 ns/op	       0 B/op	       0 allocs/op
+cpu: Intel(R) Xeon(R) Processor @ 2.10GHz
+Benchmark_Serial/fib         	 4834317	       257.5 ns/op	      16 B/op	       2 allocs/op
+Benchmark_Serial/empty       	 7641958	       167.1 ns/op	       0 B/op	       0 allocs/op
+Benchmark_Serial/update      	 1000000	      1209 ns/op	     224 B/op	      14 allocs/op
+Benchmark_Serial/table       	  822399	      1261 ns/op	     920 B/op	      16 allocs/op
+Benchmark_Serial/sleep-sigle 	     100	  10358625 ns/op	       1 B/op	       0 allocs/op
+Benchmark_Serial/sleep-multi 	     100	  10370050 ns/op	       0 B/op	       0 allocs/op
 */
 func Benchmark_Serial(b *testing.B) {
 	b.Run("fib", func(b *testing.B) {
@@ -97,9 +102,9 @@ func Benchmark_Serial(b *testing.B) {
 }
 
 /*
-cpu: Intel(R) Core(TM) i7-9750H CPU @ 2.60GHz
-Benchmark_Module/echo-12         	 2925387	       405.3 ns/op	      48 B/op	       3 allocs/op
-Benchmark_Module/hash-12         	 3037982	       388.2 ns/op	      32 B/op	       3 allocs/op
+cpu: Intel(R) Xeon(R) Processor @ 2.10GHz
+Benchmark_Module/echo        	 2958900	       387.4 ns/op	      48 B/op	       3 allocs/op
+Benchmark_Module/hash        	 1000000	      1024 ns/op	     144 B/op	       9 allocs/op
 */
 func Benchmark_Module(b *testing.B) {
 	b.Run("echo", func(b *testing.B) {
@@ -286,7 +291,7 @@ func TestPooledTables(t *testing.T) {
 
 func TestNewScript(t *testing.T) {
 	f, _ := os.Open("fixtures/json.lua")
-	s, err := New("test.lua", f, 10)
+	s, err := New("test.lua", f, WithConcurrency(10))
 	assert.NoError(t, err)
 	assert.Equal(t, 10, s.Concurrency())
 }
@@ -311,3 +316,266 @@ func TestEmptyArray(t *testing.T) {
 		"empties": [[]]
 	}`, string(b))
 }
+
+func TestCall(t *testing.T) {
+	s, err := newScript("fixtures/entry.lua")
+	assert.NoError(t, err)
+
+	out, err := s.Call(context.Background(), "validate", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, Bool(true), out)
+
+	out, err = s.Call(context.Background(), "transform", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, Number(20), out)
+
+	out, err = s.Call(context.Background(), "on_error", "boom")
+	assert.NoError(t, err)
+	assert.Equal(t, String("error: boom"), out)
+}
+
+func TestCallNotFound(t *testing.T) {
+	s, err := newScript("fixtures/entry.lua")
+	assert.NoError(t, err)
+
+	var notFound *FunctionNotFoundError
+	_, err = s.Call(context.Background(), "missing")
+	assert.ErrorAs(t, err, &notFound)
+	assert.Equal(t, "missing", notFound.Name)
+
+	_, err = s.Run(context.Background())
+	assert.ErrorAs(t, err, &notFound)
+	assert.Equal(t, "main", notFound.Name)
+}
+
+func TestCallUpdate(t *testing.T) {
+	s, err := newScript("fixtures/entry.lua")
+	assert.NoError(t, err)
+
+	out, err := s.Call(context.Background(), "transform", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, Number(20), out)
+
+	// Update the script, functions must be resolved again
+	assert.NoError(t, s.Update(strings.NewReader(`
+	function transform(input)
+		return input * 3
+	end`)))
+
+	out, err = s.Call(context.Background(), "transform", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, Number(30), out)
+
+	// A failed update must keep the previous version running
+	assert.Error(t, s.Update(strings.NewReader(`error("boom")`)))
+	out, err = s.Call(context.Background(), "transform", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, Number(30), out)
+}
+
+func TestCallMulti(t *testing.T) {
+	s, err := FromString("test.lua", `
+	function main(a, b)
+		return a + b, a * b
+	end
+
+	function none()
+	end`)
+	assert.NoError(t, err)
+
+	out, err := s.RunMulti(context.Background(), 2, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{Number(5), Number(6)}, out)
+
+	out, err = s.CallMulti(context.Background(), "none")
+	assert.NoError(t, err)
+	assert.Empty(t, out)
+
+	// Single-value call must only see the first value
+	v, err := s.Run(context.Background(), 2, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, Number(5), v)
+
+	_, err = s.CallMulti(context.Background(), "missing")
+	assert.Error(t, err)
+}
+
+func TestUpdateNonBlocking(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	local api = require("test")
+	function main(ms)
+		api.sleep(ms)
+		return 1
+	end`), WithConcurrency(1), WithModules(testModule()))
+	assert.NoError(t, err)
+
+	// Start a slow run, which occupies the only VM of the pool
+	result := make(chan Value)
+	go func() {
+		out, _ := s.Run(context.Background(), 100)
+		result <- out
+	}()
+
+	// The update must not wait for the run in progress
+	time.Sleep(5 * time.Millisecond)
+	start := time.Now()
+	assert.NoError(t, s.Update(strings.NewReader(`
+	function main()
+		return 2
+	end`)))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	// New runs are served by the new version, while the old one completes
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Number(2), out)
+	assert.Equal(t, Number(1), <-result)
+}
+
+func TestUpdateContext(t *testing.T) {
+	s, err := FromString("test.lua", `
+	function main()
+		return 1
+	end`)
+	assert.NoError(t, err)
+
+	// A cancelled update must keep the previous version
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, s.UpdateContext(ctx, strings.NewReader(`
+	function main()
+		return 2
+	end`)), context.Canceled)
+
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Number(1), out)
+}
+
+func TestUpdateConcurrent(t *testing.T) {
+	s, err := FromString("test.lua", `
+	function main()
+		return 0
+	end`)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := s.Run(context.Background())
+				assert.NoError(t, err)
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, s.Update(strings.NewReader(fmt.Sprintf(`
+		function main()
+			return %d
+		end`, i))))
+	}
+
+	wg.Wait()
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Number(9), out)
+	assert.NoError(t, s.Close())
+}
+
+func TestInit(t *testing.T) {
+	const code = `
+	local settings = {}
+	function init(config)
+		settings.name = config.name
+		settings.count = (settings.count or 0) + 1
+	end
+
+	function main()
+		return settings.name .. settings.count
+	end`
+
+	s, err := New("test.lua", strings.NewReader(code), WithConfig(ValueOf(map[string]any{
+		"name": "test",
+	})))
+	assert.NoError(t, err)
+
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, String("test1"), out)
+
+	// The init function is called again on every update
+	assert.NoError(t, s.Update(strings.NewReader(code+"\n")))
+	out, err = s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, String("test1"), out)
+}
+
+func TestInitError(t *testing.T) {
+	const code = `
+	function init(config)
+		if config == nil then
+			error("missing config")
+		end
+	end
+	function main() return 1 end`
+
+	_, err := New("test.lua", strings.NewReader(code))
+	assert.ErrorContains(t, err, "missing config")
+
+	s, err := New("test.lua", strings.NewReader(code), WithConfig(Number(1)))
+	assert.NoError(t, err)
+
+	// A failing init keeps the current version running
+	assert.Error(t, s.Update(strings.NewReader(`
+	function init() error("broken") end
+	function main() return 2 end`)))
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Number(1), out)
+}
+
+func TestGlobals(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`
+	local prefix = tenant .. ":"
+	function main(key)
+		if flags.enabled then
+			return prefix .. key .. "=" .. limits[key]
+		end
+		return prefix .. key
+	end`), WithGlobals(map[string]any{
+		"tenant": "acme",
+		"flags":  Table{"enabled": Bool(true)},
+		"limits": map[string]int{"a": 10},
+	}))
+	assert.NoError(t, err)
+
+	out, err := s.Run(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, String("acme:a=10"), out)
+
+	// Replace the globals without recompiling
+	version := s.Version()
+	assert.NoError(t, s.SetGlobals(map[string]any{
+		"tenant": "other",
+		"flags":  Table{"enabled": Bool(false)},
+	}))
+	assert.Equal(t, version, s.Version())
+
+	out, err = s.Run(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, String("other:a"), out)
+
+	// Globals the script fails with are rejected, keeping the current ones
+	assert.Error(t, s.SetGlobals(nil))
+	out, err = s.Run(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, String("other:a"), out)
+
+	// Scripts which failed to compile have no version to apply the globals to
+	invalid, err := New("test.lua", strings.NewReader(`function main(`))
+	assert.Error(t, err)
+	assert.ErrorIs(t, invalid.SetGlobals(map[string]any{"a": 1}), errInvalidScript)
+}
@@ -4,6 +4,7 @@
 package lua
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -21,6 +22,7 @@ type tableType interface {
 
 var (
 	typeError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeContext = reflect.TypeOf((*context.Context)(nil)).Elem()
 	typeNumber  = reflect.TypeOf(Number(0))
 	typeString  = reflect.TypeOf(String(""))
 	typeBool    = reflect.TypeOf(Bool(true))
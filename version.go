@@ -0,0 +1,100 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ErrVersionNotFound is returned when rolling back to a version which is not in
+// the history of the script.
+var ErrVersionNotFound = errors.New("lua: version not found")
+
+// Version represents a compiled version of the script
+type Version struct {
+	Hash string             // The SHA-256 hash of the source, hex encoded
+	Time time.Time          // The time the version was compiled
+	code *lua.FunctionProto // The precompiled code
+}
+
+// newVersion creates a new version from the source and its compiled code
+func newVersion(source []byte, code *lua.FunctionProto) *Version {
+	hash := sha256.Sum256(source)
+	return &Version{
+		Hash: hex.EncodeToString(hash[:]),
+		Time: time.Now(),
+		code: code,
+	}
+}
+
+// Version returns the version of the script which is currently running.
+func (s *Script) Version() Version {
+	if v := s.version.Load(); v != nil {
+		return *v
+	}
+	return Version{}
+}
+
+// History returns the versions of the script which are kept, from the oldest to
+// the most recent one.
+func (s *Script) History() []Version {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	out := make([]Version, 0, len(s.history))
+	for _, v := range s.history {
+		out = append(out, *v)
+	}
+	return out
+}
+
+// Rollback replaces the running version of the script with a version from its
+// history, identified by its hash, without recompiling it.
+func (s *Script) Rollback(version string) error {
+	return s.RollbackContext(context.Background(), version)
+}
+
+// RollbackContext replaces the running version of the script with a version from
+// its history, identified by its hash. If the context is done before the new VMs
+// are ready, the rollback is aborted.
+func (s *Script) RollbackContext(ctx context.Context, version string) error {
+	s.lock.Lock()
+	err := ErrVersionNotFound
+	for _, v := range s.history {
+		if v.Hash == version {
+			err = s.apply(ctx, v)
+			break
+		}
+	}
+	s.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return s.reload(ctx)
+}
+
+// remember adds the version to the history, evicting the oldest versions once
+// the history is full. This must be called while holding the lock.
+func (s *Script) remember(version *Version) {
+	history := make([]*Version, 0, len(s.history)+1)
+	for _, v := range s.history {
+		if v.Hash != version.Hash {
+			history = append(history, v)
+		}
+	}
+
+	history = append(history, version)
+	if n := len(history) - s.conf.history; n > 0 {
+		history = history[n:]
+	}
+
+	s.history = history
+}
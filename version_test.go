@@ -0,0 +1,75 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionUnchanged(t *testing.T) {
+	const code = `function main() return 1 end`
+	s, err := FromString("test.lua", code)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	pool := s.pool.Load()
+	version := s.Version()
+	assert.Len(t, version.Hash, 64)
+
+	// Identical source must not rebuild the pool
+	assert.NoError(t, s.Update(strings.NewReader(code)))
+	assert.Equal(t, pool, s.pool.Load())
+	assert.Equal(t, version, s.Version())
+	assert.Len(t, s.History(), 1)
+}
+
+func TestVersionRollback(t *testing.T) {
+	s, err := FromString("test.lua", `function main() return 1 end`)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	first := s.Version()
+	assert.NoError(t, s.Update(strings.NewReader(`function main() return 2 end`)))
+	assert.NotEqual(t, first.Hash, s.Version().Hash)
+
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Number(2), out)
+
+	// Roll back to the first version
+	assert.NoError(t, s.Rollback(first.Hash))
+	assert.Equal(t, first, s.Version())
+	assert.Len(t, s.History(), 2)
+
+	out, err = s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Number(1), out)
+
+	// Unknown versions are rejected
+	assert.ErrorIs(t, s.Rollback("unknown"), ErrVersionNotFound)
+}
+
+func TestVersionHistory(t *testing.T) {
+	s, err := New("test.lua", strings.NewReader(`function main() return 0 end`), WithHistory(2))
+	assert.NoError(t, err)
+	defer s.Close()
+
+	first := s.Version()
+	assert.NoError(t, s.Update(strings.NewReader(`function main() return 1 end`)))
+	assert.NoError(t, s.Update(strings.NewReader(`function main() return 2 end`)))
+
+	// The oldest version was evicted
+	history := s.History()
+	assert.Len(t, history, 2)
+	assert.Equal(t, s.Version(), history[1])
+	assert.ErrorIs(t, s.Rollback(first.Hash), ErrVersionNotFound)
+
+	// Failed updates are not kept
+	assert.Error(t, s.Update(strings.NewReader(`function main(`)))
+	assert.Equal(t, history, s.History())
+}
@@ -0,0 +1,83 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// watchInterval is the interval at which the watched files are polled
+var watchInterval = 500 * time.Millisecond
+
+// Watch polls the file at the path provided and updates the script whenever the
+// file changes, until the context is done or the script is closed. A change is
+// only applied once the file is no longer being written to, and the errors which
+// occur while reloading, such as compile errors, are reported to the callback
+// while the current version keeps running. Watch blocks, so it is typically run
+// in its own goroutine. The script is first updated with the content of the file,
+// which does nothing if it is identical to the running version.
+func (s *Script) Watch(ctx context.Context, path string, onError func(error)) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	var last, pending os.FileInfo
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if s.done.Load() {
+				return ErrClosed
+			}
+		}
+
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			pending = nil
+			report(onError, err)
+			continue
+		case last != nil && unchanged(info, last):
+			pending = nil
+			continue
+		case pending == nil || !unchanged(info, pending):
+			pending = info // Wait until the writes settle
+			continue
+		}
+
+		last, pending = info, nil
+		if err := s.updateFile(ctx, path); err != nil {
+			report(onError, err)
+		}
+	}
+}
+
+// updateFile updates the script with the content of a file
+func (s *Script) updateFile(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	return s.UpdateContext(ctx, f)
+}
+
+// unchanged returns whether the file seems to be the same
+func unchanged(info, prev os.FileInfo) bool {
+	return info.Size() == prev.Size() && info.ModTime().Equal(prev.ModTime())
+}
+
+// report reports an error to the callback, if any
+func report(onError func(error), err error) {
+	if onError != nil {
+		onError(err)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package lua
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	defer func(v time.Duration) { watchInterval = v }(watchInterval)
+	watchInterval = 5 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "test.lua")
+	assert.NoError(t, os.WriteFile(path, []byte(`function main() return 1 end`), 0644))
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	s, err := New("test.lua", f)
+	assert.NoError(t, f.Close())
+	assert.NoError(t, err)
+
+	var lock sync.Mutex
+	var errs []error
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Watch(ctx, path, func(err error) {
+			lock.Lock()
+			errs = append(errs, err)
+			lock.Unlock()
+		})
+	}()
+
+	// Changes are picked up
+	assert.NoError(t, os.WriteFile(path, []byte(`function main() return 22 end`), 0644))
+	assert.Eventually(t, func() bool {
+		out, err := s.Run(context.Background())
+		return err == nil && out == Number(22)
+	}, time.Second, time.Millisecond)
+
+	// Compile errors are reported, and the working version keeps running
+	assert.NoError(t, os.WriteFile(path, []byte(`function main(`), 0644))
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(errs) > 0
+	}, time.Second, time.Millisecond)
+
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Number(22), out)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.NoError(t, s.Close())
+}
+
+func TestWatchClosed(t *testing.T) {
+	defer func(v time.Duration) { watchInterval = v }(watchInterval)
+	watchInterval = 5 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "test.lua")
+	assert.NoError(t, os.WriteFile(path, []byte(`function main() return 1 end`), 0644))
+
+	s, err := FromString("test.lua", `function main() return 1 end`)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	assert.ErrorIs(t, s.Watch(context.Background(), path, nil), ErrClosed)
+}
+
+func TestWatchMissing(t *testing.T) {
+	s, err := FromString("test.lua", `function main() return 1 end`)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	assert.Error(t, s.Watch(context.Background(), "missing.lua", nil))
+}
+
+func TestReloadDependents(t *testing.T) {
+	m, err := FromString("module.lua", `
+	local module = {}
+	function module.value() return 1 end
+	return module`)
+	assert.NoError(t, err)
+
+	s, err := FromString("test.lua", `
+	local mod = require("mod")
+	function main() return mod.value() end`, &ScriptModule{
+		Script: m,
+		Name:   "mod",
+	})
+	assert.NoError(t, err)
+
+	out, err := s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Number(1), out)
+
+	// Updating the module reloads the script using it
+	assert.NoError(t, m.Update(strings.NewReader(`
+	local module = {}
+	function module.value() return 2 end
+	return module`)))
+
+	out, err = s.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Number(2), out)
+
+	// Once closed, the script is no longer reloaded
+	assert.NoError(t, s.Close())
+	assert.Len(t, m.users, 0)
+	assert.NoError(t, m.Close())
+}